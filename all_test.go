@@ -1,13 +1,21 @@
 package sqlmaker
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 var db *sql.DB
@@ -108,10 +116,21 @@ func TestQueryMany(t *testing.T) {
 		fmt.Println(u)
 	}
 
+	// 直接解码到切片中
+	users := make([]*User, 0)
+	err = NewQueryMaker(user).SetDB(db).Cond(cond).ExecQueryAll(&users)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Query All: ", len(users))
+
 	// 查询单个数据
 	maker = NewQueryMaker(user).ByID().SetDB(db)
 	u := User{}
-	_ = maker.ExecQueryOne(&u)
+	err = maker.ExecQueryOne(&u)
+	if errors.Is(err, ErrNotFound) {
+		fmt.Println("Query One: not found")
+	}
 	fmt.Println("Query One: ", u)
 
 	// 统计个数
@@ -122,6 +141,690 @@ func TestQueryMany(t *testing.T) {
 
 }
 
+func TestExecQueryAll(t *testing.T) {
+
+	rows := func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+		return &Result{Rows: [][]interface{}{
+			{int64(1), []byte("Mike")},
+			{int64(2), []byte("Tang")},
+		}}, nil
+	}
+	maker := NewQueryMaker(user).Filter("id", "name").Use(rows)
+
+	users := make([]User, 0)
+	if err := maker.ExecQueryAll(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Id != 1 || users[0].Name != "Mike" || users[1].Id != 2 || users[1].Name != "Tang" {
+		t.Errorf("unexpected users: %v", users)
+	}
+
+	pointers := []*User{{Id: 9}}
+	if err := maker.ExecQueryAll(&pointers); err != nil {
+		t.Fatal(err)
+	}
+	if len(pointers) != 3 || pointers[1].Id != 1 || pointers[2].Name != "Tang" {
+		t.Errorf("rows should be appended, got %v", pointers)
+	}
+
+	ints := make([]int, 0)
+	for _, dest := range []interface{}{users, &user, &ints, nil} {
+		if err := maker.ExecQueryAll(dest); !errors.Is(err, DestNotSliceError) {
+			t.Errorf("expect DestNotSliceError for %T, got %v", dest, err)
+		}
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+
+	maker := NewQueryMaker(user).SetDB(db).ByID()
+	before := GetStmtCacheStats(db)
+	for i := 0; i < 3; i++ {
+		u := User{}
+		if err := maker.ExecQueryOne(&u); err != nil && !errors.Is(err, ErrNotFound) {
+			t.Fatal(err)
+		}
+	}
+	after := GetStmtCacheStats(db)
+	if after.Hits-before.Hits < 2 {
+		t.Errorf("expect at least 2 cache hits, got %d", after.Hits-before.Hits)
+	}
+}
+
+func TestStmtCacheContext(t *testing.T) {
+
+	// 缓存未命中时Prepare使用SetContext设置的context，被取消的context不会访问数据库
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewQueryMaker(user).SetDB(db).ByID().SetContext(ctx).ExecQueryOne(&User{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context.Canceled, got %v", err)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+
+	err := translateError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	if !errors.Is(err, ErrDeadlock) {
+		t.Errorf("expect ErrDeadlock, got %v", err)
+	}
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) || myErr.Number != 1213 {
+		t.Errorf("expect wrapped mysql error, got %v", err)
+	}
+
+	err = translateError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	if !errors.Is(err, ErrDuplicateKey) || errors.Is(err, ErrForeignKey) {
+		t.Errorf("expect ErrDuplicateKey, got %v", err)
+	}
+
+	origin := errors.New("unknown")
+	if translateError(origin) != origin {
+		t.Errorf("unknown error should not be translated")
+	}
+}
+
+type recordLogger struct {
+	levels []LogLevel
+	fields []LogFields
+}
+
+func (l *recordLogger) Log(level LogLevel, msg string, fields LogFields) {
+	l.levels = append(l.levels, level)
+	l.fields = append(l.fields, fields)
+}
+
+func TestLogger(t *testing.T) {
+
+	rec := &recordLogger{}
+	maker := NewQueryMaker(user).SetLogger(rec).SetLogLevel(LevelWarn)
+	maker.log(LevelDebug, "query", LogFields{SQL: "SELECT 1"})
+	maker.log(LevelError, "query", LogFields{SQL: "SELECT 2"})
+	if len(rec.fields) != 1 || rec.fields[0].SQL != "SELECT 2" {
+		t.Errorf("expect only the error record, got %v", rec.fields)
+	}
+
+	buf := &bytes.Buffer{}
+	l := NewStdLogger(log.New(buf, "", 0), false)
+	l.Log(LevelInfo, "exec", LogFields{SQL: "DELETE FROM user", RowsAffected: 2})
+	expect := "[sqlmaker-INFO] exec sql=DELETE FROM user rows=2\n"
+	if buf.String() != expect {
+		t.Errorf("expect %q, got %q", expect, buf.String())
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+
+	rec := &recordLogger{}
+	u := User{Id: 7, Name: "Mike"}
+	cond := NewPrepareCond().Eq("name", u.Name).And().Eq("id", u.Id)
+	maker := NewQueryMaker(u).Cond(cond).SetLogger(rec).
+		SetLogLevel(LevelWarn).SetSlowThreshold(time.Millisecond)
+	stmt := &Statement{SQL: maker.BuildMake(), Args: maker.Values(), caller: callerLocation()}
+
+	maker.logExec("query", stmt, time.Now(), 1, nil)
+	if len(rec.fields) != 0 {
+		t.Fatalf("fast query should not be logged")
+	}
+
+	maker.logExec("query", stmt, time.Now().Add(-time.Second), 1, nil)
+	if len(rec.fields) != 1 || rec.levels[0] != LevelWarn {
+		t.Fatalf("expect one slow query record, got %v", rec.fields)
+	}
+	fields := rec.fields[0]
+	if !strings.Contains(fields.SQL, "`name`='Mike' AND `id`=7") {
+		t.Errorf("unexpected interpolated sql: %s", fields.SQL)
+	}
+	if len(fields.Args) != 2 || fields.RowsAffected != 1 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if !strings.Contains(fields.Caller, "all_test.go:") {
+		t.Errorf("unexpected caller: %s", fields.Caller)
+	}
+
+	// 调用者在进入Hook链之前获取，不会被Hook所在的位置取代
+	var caller string
+	maker.Use(func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+		caller = stmt.caller
+		return nil, nil
+	})
+	_, file, line, _ := runtime.Caller(0)
+	_, _ = maker.ExecQueryMany()
+	if expect := fmt.Sprintf("%s:%d", file, line+1); caller != expect {
+		t.Errorf("expect caller %s, got %s", expect, caller)
+	}
+}
+
+func TestHook(t *testing.T) {
+
+	var seen []Statement
+	maker := NewQueryMaker(user).ByID().Use(
+		func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+			seen = append(seen, *stmt)
+			stmt.SQL += " FOR UPDATE"
+			return next(ctx, stmt)
+		},
+		func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+			// 短路，不再真正执行
+			if stmt.Op == OpCount {
+				return &Result{Count: 42}, nil
+			}
+			if !strings.HasSuffix(stmt.SQL, " FOR UPDATE") {
+				t.Errorf("statement should be rewritten: %s", stmt.SQL)
+			}
+			return &Result{Rows: [][]interface{}{{int64(3), []byte("Mike")}}}, nil
+		},
+	).Filter("id", "name")
+
+	u := User{}
+	if err := maker.ExecQueryOne(&u); err != nil || u.Id != 3 || u.Name != "Mike" {
+		t.Errorf("unexpected result: %v, %v", u, err)
+	}
+	cnt, err := maker.ExecCount()
+	if err != nil || cnt != 42 {
+		t.Errorf("unexpected count: %d, %v", cnt, err)
+	}
+	if len(seen) != 2 || seen[0].Op != OpSelect || seen[0].Table != "user" ||
+		seen[1].Op != OpCount || len(seen[0].Args) != 1 {
+		t.Errorf("unexpected statements: %v", seen)
+	}
+
+	// 短路的Hook返回nil结果时，不会panic
+	noop := func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+		return nil, nil
+	}
+	if err := NewQueryMaker(User{}).ByID().Use(noop).ExecQueryOne(&u); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound, got %v", err)
+	}
+	if result, err := NewQueryMaker(User{}).Use(noop).ExecQueryMany(); err != nil || result.Next() {
+		t.Errorf("expect empty result, got %v", err)
+	}
+	if cnt, err := NewQueryMaker(User{}).Use(noop).ExecCount(); err != nil || cnt != 0 {
+		t.Errorf("unexpected count: %d, %v", cnt, err)
+	}
+	if affected, err := NewUpdateMaker(User{}).ByID().Use(noop).Exec(); err != nil || affected != 0 {
+		t.Errorf("unexpected affected: %d, %v", affected, err)
+	}
+}
+
+func TestMemoryMetrics(t *testing.T) {
+
+	m := NewMemoryMetrics(0.01, 0.1)
+	m.Observe("user", OpSelect, OutcomeSuccess, 5*time.Millisecond)
+	m.Observe("user", OpSelect, OutcomeSuccess, 50*time.Millisecond)
+	m.Observe("user", OpInsert, OutcomeError, time.Second)
+
+	if m.Count("user", OpSelect, OutcomeSuccess) != 2 {
+		t.Errorf("expect 2 selects")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE sqlmaker_queries_total counter",
+		`sqlmaker_queries_total{table="user",operation="insert",outcome="error"} 1`,
+		`sqlmaker_queries_total{table="user",operation="select",outcome="success"} 2`,
+		`sqlmaker_query_duration_seconds_bucket{table="user",operation="select",outcome="success",le="0.01"} 1`,
+		`sqlmaker_query_duration_seconds_bucket{table="user",operation="select",outcome="success",le="0.1"} 2`,
+		`sqlmaker_query_duration_seconds_bucket{table="user",operation="insert",outcome="error",le="+Inf"} 1`,
+		`sqlmaker_query_duration_seconds_count{table="user",operation="insert",outcome="error"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+
+	rec := NewSpanRecorder()
+	SetTracer(rec)
+	defer SetTracer(nil)
+
+	ctx, parent := rec.StartSpan(context.Background(), "handler")
+	maker := NewQueryMaker(user).ByID().SetDB(db).SetContext(ctx)
+	_ = maker.ExecQueryOne(&User{})
+	parent.End()
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans, got %d", len(spans))
+	}
+	span := spans[1]
+	if span.Name != "select user" || span.Parent != parent || !span.Ended() {
+		t.Errorf("unexpected span: %+v", span)
+	}
+	if span.Attributes["db.system"] != "mysql" || span.Attributes["db.sql.table"] != "user" ||
+		span.Attributes["db.statement"] != maker.MustMake() {
+		t.Errorf("unexpected attributes: %v", span.Attributes)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cond := NewPrepareCond().Eq("name", "O'Brien\\?").And().Eq("create_date", date).
+		And().Eq("phone", nil).And().Eq("status", []byte{0xff, 0x01})
+	maker := NewQueryMaker(user).Filter("id").Cond(cond)
+
+	expect := "SELECT `id` FROM user WHERE `name`='O\\'Brien\\\\?' AND " +
+		"`create_date`='2020-01-02 03:04:05' AND `phone`=NULL AND `status`=X'ff01'"
+	if s, err := maker.Interpolate(); err != nil || s != expect {
+		t.Errorf("expect %s, got %s, %v", expect, s, err)
+	}
+
+	// 无法生成SQL时返回错误而不是panic
+	if _, err := NewUpdateMaker(user).Interpolate(); !errors.Is(err, ErrUnsafeStatement) {
+		t.Errorf("expect ErrUnsafeStatement, got %v", err)
+	}
+}
+
+func TestStrictColumns(t *testing.T) {
+
+	cond := NewPrepareCond().Eq("user.name", "Mike").And().Eq("age` OR 1=1 --", 1)
+	_sql, err := NewQueryMaker(user).Filter("id").Cond(cond).Build().Make()
+	expect := "SELECT `id` FROM user WHERE `user`.`name`=? AND `age`` OR 1=1 --`=?"
+	if err != nil || _sql != expect {
+		t.Errorf("expect %s, got %s, %v", expect, _sql, err)
+	}
+
+	_, err = NewQueryMaker(user).Cond(cond).StrictColumns().Build().Make()
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("expect ErrUnknownColumn, got %v", err)
+	}
+
+	cond = NewPrepareCond().Eq("user.name", "Mike").And().Eq("create_date", time.Now())
+	if _, err = NewQueryMaker(user).Cond(cond).StrictColumns().Build().Make(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCondTree(t *testing.T) {
+
+	cond := NewPrepareCond().Eq("a", 1).AndAll().Eq("b", 2).Or().Eq("c", 3).
+		AndAll().Eq("d", 4).EndAll().EndAll().Or().Like("e", "%5%").Eq("f", 6)
+
+	expect := "`a`=? AND (`b`=? OR `c`=? AND (`d`=?)) OR `e` LIKE ? AND `f`=?"
+	for i := 0; i < 2; i++ {
+		if s := cond.Make(); s != expect {
+			t.Errorf("expect %s, got %s", expect, s)
+		}
+	}
+	if fmt.Sprint(cond.Values()) != "[1 2 3 4 %5% 6]" {
+		t.Errorf("unexpected values: %v", cond.Values())
+	}
+	if fmt.Sprint(cond.Columns()) != "[a b c d e f]" {
+		t.Errorf("unexpected columns: %v", cond.Columns())
+	}
+
+	groups := 0
+	cond.Walk(func(node Node) bool {
+		if _, ok := node.(*GroupNode); ok {
+			groups++
+		}
+		return true
+	})
+	if groups != 2 {
+		t.Errorf("expect 2 groups, got %d", groups)
+	}
+
+	// 没有关闭的括号会被自动关闭，并且之后还可以继续增加条件
+	cond = NewCond().Eq("a", 1).OrAll().Eq("b", "x")
+	if s := cond.Make(); s != "`a`=1 OR (`b`='x')" {
+		t.Errorf("unexpected cond: %s", s)
+	}
+	if s := cond.Eq("c", 2).EndAll().Make(); s != "`a`=1 OR (`b`='x' AND `c`=2)" {
+		t.Errorf("unexpected cond: %s", s)
+	}
+}
+
+func TestCondFunc(t *testing.T) {
+
+	cond := And(Eq("a", 1), Or(Eq("b", 2), nil, NewPrepareCond(), Eq("c", 3)),
+		Not(Or(Eq("d", 4))), And(), Lt("e", 5))
+	expect := "`a`=? AND (`b`=? OR `c`=?) AND NOT (`d`=?) AND `e`>?"
+	if s := cond.Make(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(cond.Values()) != "[1 2 3 4 5]" {
+		t.Errorf("unexpected values: %v", cond.Values())
+	}
+
+	// 函数式和链式调用可以混合使用，函数生成的条件是一个整体
+	cond = Or(And(Eq("a", 1), Eq("b", 2)), Eq("c", 3)).And().Eq("d", 4)
+	expect = "(`a`=? AND `b`=? OR `c`=?) AND `d`=?"
+	if s := cond.Make(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if !And(nil, Not(nil)).Empty() {
+		t.Errorf("expect empty cond")
+	}
+
+	// 所有子条件都为空时不会生成WHERE子句
+	maker := NewQueryMaker(user).Filter("id").Cond(And(NewPrepareCond(), Or(nil, NewCond())))
+	if s := maker.BuildMake(); s != "SELECT `id` FROM user" || len(maker.Values()) != 0 {
+		t.Errorf("unexpected sql: %s %v", s, maker.Values())
+	}
+}
+
+func TestCondOperators(t *testing.T) {
+
+	cond := NewPrepareCond().Like("name", "M%").Between("age", 18, 30).NotBetween("status", 3, 4).
+		IsNull("phone").Or().IsNotNull("create_date").NotLike("name", "%x").
+		ILike("name", "mi%").Regexp("phone", "^1[0-9]+$").Contains("name", `50%_off\`)
+
+	expect := "`name` LIKE ? AND `age` BETWEEN ? AND ? AND `status` NOT BETWEEN ? AND ? AND " +
+		"`phone` IS NULL OR `create_date` IS NOT NULL AND `name` NOT LIKE ? AND " +
+		"LOWER(`name`) LIKE LOWER(?) AND `phone` REGEXP ? AND `name` LIKE ?"
+	if s := cond.Make(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	values := cond.Values()
+	if len(values) != 9 || values[8] != `%50\%\_off\\%` {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	if s := NewCond().StartsWith("name", "a_b").Make(); s != "`name` LIKE 'a\\\\_b%'" {
+		t.Errorf("unexpected cond: %s", s)
+	}
+}
+
+func TestCondIn(t *testing.T) {
+
+	cond := NewPrepareCond().In("id", []int{1, 2, 3}).NotIn("name", []string{"a"}).
+		In("age", []interface{}{}).NotIn("status", []int64{})
+	expect := "`id` IN (?,?,?) AND `name` NOT IN (?) AND 1=0 AND 1=1"
+	if s := cond.Make(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(cond.Values()) != "[1 2 3 a]" {
+		t.Errorf("unexpected values: %v", cond.Values())
+	}
+
+	SetInChunkSize(2)
+	defer SetInChunkSize(0)
+	cond = NewCond().In("id", []int{1, 2, 3}).Or().NotIn("id", []int{4, 5, 6, 7, 8})
+	expect = "(`id` IN (1,2) OR `id` IN (3)) OR " +
+		"(`id` NOT IN (4,5) AND `id` NOT IN (6,7) AND `id` NOT IN (8))"
+	if s := cond.Make(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+}
+
+func TestCondFromExample(t *testing.T) {
+
+	cond := CondFromExample(User{Name: "Mike", Status: 2})
+	if s := cond.Make(); s != "`name`=? AND `status`=?" {
+		t.Errorf("unexpected cond: %s", s)
+	}
+	if fmt.Sprint(cond.Values()) != "[Mike 2]" {
+		t.Errorf("unexpected values: %v", cond.Values())
+	}
+
+	cond = CondFromExample(&User{Name: "Mi_"}, LikeStrings(), IncludeZero("age", "Status"))
+	if s := cond.Make(); s != "`name` LIKE ? AND `age`=? AND `status`=?" {
+		t.Errorf("unexpected cond: %s", s)
+	}
+	if fmt.Sprint(cond.Values()) != "[%Mi\\_% 0 0]" {
+		t.Errorf("unexpected values: %v", cond.Values())
+	}
+
+	// 没有任何非零值字段时不会生成WHERE子句
+	if s := NewQueryMaker(user).Filter("id").Cond(CondFromExample(User{})).BuildMake(); s != "SELECT `id` FROM user" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+
+	spec := NewFilterSpec(user).Allow("status").Allow("age", FilterGte, FilterIn).
+		Allow("name", FilterLike).Sortable("create_date", "id")
+
+	filter, err := ParseFilterString("status=2&age__gte=18&name__like=mi&sort=-create_date,id&page=2", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sql := filter.Apply(NewQueryMaker(user).Filter("id")).BuildMake()
+	expect := "SELECT `id` FROM user WHERE `age`>=? AND `name` LIKE ? AND `status`=? " +
+		"ORDER BY `create_date` DESC,`id` ASC LIMIT 20,20"
+	if _sql != expect {
+		t.Errorf("expect %s, got %s", expect, _sql)
+	}
+	if fmt.Sprint(filter.Cond.Values()) != "[18 %mi% 2]" {
+		t.Errorf("unexpected values: %v", filter.Cond.Values())
+	}
+
+	_, err = ParseFilterString("age=1&age__in=1,x&phone=1&sort=name&page_size=1000", spec)
+	var errs FilterErrors
+	if !errors.As(err, &errs) || len(errs) != 5 {
+		t.Fatalf("expect 5 errors, got %v", err)
+	}
+	for i, param := range []string{"age", "age__in", "page_size", "phone", "sort"} {
+		if errs[i].Param != param {
+			t.Errorf("expect error for %s, got %v", param, errs[i])
+		}
+	}
+
+	// 没有筛选参数时不会生成WHERE子句
+	filter, err = ParseFilterString("page=2", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := filter.Apply(NewQueryMaker(user).Filter("id")).BuildMake(); s != "SELECT `id` FROM user LIMIT 20,20" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+
+	// 重复的参数和过大的页码也是校验错误
+	_, err = ParseFilterString("status=1&status=2&page=10001", spec)
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Param != "page" || errs[1].Param != "status" {
+		t.Errorf("expect errors for page and status, got %v", err)
+	}
+}
+
+func TestCondJSON(t *testing.T) {
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	cond := And(Eq("name", "Mike"), Or(In("id", []int64{1, 2}), IsNull("phone")),
+		Not(Between("create_date", date, date.Add(time.Hour))), ILike("phone", []byte("13")),
+		Eq("age", 18), Eq("status", nil), Eq("score", 1.5), NewPrepareCond().Eq("a", 1).OrAll().Eq("b", true))
+
+	data, err := json.Marshal(cond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewCond()
+	if err = json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Make() != cond.Make() || !reflect.DeepEqual(restored.Values(), cond.Values()) {
+		t.Errorf("expect %s %v, got %s %v", cond.Make(), cond.Values(), restored.Make(), restored.Values())
+	}
+
+	again, _ := json.Marshal(restored)
+	if string(again) != string(data) {
+		t.Errorf("json is not stable:\n%s\n%s", data, again)
+	}
+
+	// 其它整数类型和非UTC时间恢复后的类型和时区可能不同，但是生成的SQL和值是等价的
+	zone := time.FixedZone("CST", 8*3600)
+	cond = And(Eq("age", int32(18)), Eq("create_date", time.Date(2020, 1, 2, 3, 4, 5, 0, zone)))
+	data, _ = json.Marshal(cond)
+	restored = NewPrepareCond()
+	if err = json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	expect := interpolate(cond.Make(), cond.Values())
+	if s := interpolate(restored.Make(), restored.Values()); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if date := restored.Values()[1].(time.Time); !date.Equal(cond.Values()[1].(time.Time)) {
+		t.Errorf("expect the same instant, got %v", date)
+	}
+
+	// JSON可能来自不可信的输入，未知的运算符和空的and/or都会被拒绝
+	for _, bad := range []string{
+		`{"prepare":true,"where":{"op":"compare","column":"id","operator":"=1 OR 1=1 -- ","value":{"type":"int","value":1}}}`,
+		`{"prepare":true,"where":{"op":"and"}}`,
+		`{"prepare":true,"where":{"op":"or","children":[]}}`,
+	} {
+		if err := json.Unmarshal([]byte(bad), NewPrepareCond()); err == nil {
+			t.Errorf("expect error for %s", bad)
+		}
+	}
+}
+
+func TestTableUpdateMaker(t *testing.T) {
+
+	// 没有id的Maker调用ByID不会生成"``=?"条件，而是被当作没有条件的更新语句拒绝
+	if _, err := NewTableUpdateMaker("user").Set("a", 1).ByID().Build().Make(); !errors.Is(err, ErrUnsafeStatement) {
+		t.Errorf("expect ErrUnsafeStatement, got %v", err)
+	}
+
+	maker := NewTableUpdateMaker("user").Set("status", 3).
+		SetExpr("age", "age + ?", 1).SetMap(map[string]interface{}{"phone": "1", "name": "x"}).
+		Cond(Eq("id", 5))
+	expect := "UPDATE user SET `status`=?,`age`=age + ?,`name`=?,`phone`=? WHERE `id`=?"
+	if s := maker.BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(maker.Values()) != "[3 1 x 1 5]" {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+
+	expect = "UPDATE user SET `status`=3,`age`=age + 1,`name`='x',`phone`='1' WHERE `id`=?"
+	if s := maker.Prepare(false).BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+
+	_, err := NewTableUpdateMaker("user").Bind(user).Set("nickname", "x").Cond(Eq("id", 5)).Build().Make()
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("expect ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestTrack(t *testing.T) {
+
+	u := user
+	Track(&u)
+	defer Untrack(&u)
+
+	maker := NewUpdateMaker(&u).ByID()
+	if s, err := maker.Build().Make(); s != "" || err != nil {
+		t.Errorf("expect no statement, got %s, %v", s, err)
+	}
+	if affect, err := maker.Exec(); affect != 0 || err != nil {
+		t.Errorf("expect nothing executed, got %d, %v", affect, err)
+	}
+
+	u.Name = "John"
+	u.Status = 5
+	maker = NewUpdateMaker(&u).ByID()
+	expect := "UPDATE user SET `name`=?,`status`=? WHERE `id`=?"
+	if s := maker.BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(maker.Values()) != fmt.Sprintf("[John 5 %d]", u.Id) {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+
+	// 没有被追踪的entity仍然更新所有字段
+	if s := NewUpdateMaker(u).ByID().Filter("name", "age").BuildMake(); s != "UPDATE user SET `name`=?,`age`=? WHERE `id`=?" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+}
+
+type Article struct {
+	Id      int    `field:"id"`
+	Title   string `field:"title"`
+	Version int    `field:"version,version"`
+}
+
+func (a Article) GetId() (string, interface{}) {
+	return "id", a.Id
+}
+
+func (a Article) TableName() string {
+	return "article"
+}
+
+func TestOptimisticLock(t *testing.T) {
+
+	a := Article{Id: 1, Title: "hello", Version: 3}
+	affected := int64(0)
+	maker := NewUpdateMaker(&a).ByID().Use(
+		func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+			return &Result{RowsAffected: affected}, nil
+		})
+
+	expect := "UPDATE article SET `id`=?,`title`=?,`version`=`version`+1 WHERE `id`=? AND `version`=?"
+	if s := maker.BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(maker.Values()) != "[1 hello 1 3]" {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+
+	if _, err := maker.Exec(); !errors.Is(err, ErrStaleObject) || a.Version != 3 {
+		t.Errorf("expect ErrStaleObject, got %v, version %d", err, a.Version)
+	}
+	affected = 1
+	if _, err := maker.Exec(); err != nil || a.Version != 4 {
+		t.Errorf("expect version 4, got %v, version %d", err, a.Version)
+	}
+
+	// 版本号可以是任意整数类型
+	a64 := Article64{Id: 1, Title: "hello", Version: 3}
+	maker = NewUpdateMaker(&a64).ByID().Use(
+		func(ctx context.Context, stmt *Statement, next Handler) (*Result, error) {
+			return &Result{RowsAffected: 1}, nil
+		})
+	expect = "UPDATE article SET `id`=?,`title`=?,`version`=`version`+1 WHERE `id`=? AND `version`=?"
+	if s := maker.BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if fmt.Sprint(maker.Values()) != "[1 hello 1 3]" {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+	if _, err := maker.Exec(); err != nil || a64.Version != 4 {
+		t.Errorf("expect version 4, got %v, version %d", err, a64.Version)
+	}
+
+	// 不是整数的版本号字段会返回错误，而不是静默地关闭乐观锁
+	if _, err := NewUpdateMaker(ArticleBadVersion{Id: 1}).ByID().Build().Make(); err == nil {
+		t.Errorf("expect error for non-integer version field")
+	}
+}
+
+type Article64 struct {
+	Id      int    `field:"id"`
+	Title   string `field:"title"`
+	Version int64  `field:"version,version"`
+}
+
+func (a Article64) GetId() (string, interface{}) {
+	return "id", a.Id
+}
+
+func (a Article64) TableName() string {
+	return "article"
+}
+
+type ArticleBadVersion struct {
+	Id      int    `field:"id"`
+	Version string `field:"version,version"`
+}
+
+func (a ArticleBadVersion) GetId() (string, interface{}) {
+	return "id", a.Id
+}
+
+func (a ArticleBadVersion) TableName() string {
+	return "article"
+}
+
 func TestOther(t *testing.T) {
 
 	i := 1
@@ -133,3 +836,153 @@ func TestOther(t *testing.T) {
 func changeVal(o *int) {
 	*o = 12
 }
+
+type Post struct {
+	Id        int        `field:"id"`
+	Title     string     `field:"title"`
+	DeletedAt *time.Time `field:"deleted_at,softDelete"`
+}
+
+func (p Post) GetId() (string, interface{}) {
+	return "id", p.Id
+}
+
+func (p Post) TableName() string {
+	return "post"
+}
+
+func TestSoftDelete(t *testing.T) {
+
+	p := Post{Id: 1, Title: "hello"}
+	cases := []struct {
+		maker  *SqlMaker
+		expect string
+	}{
+		{NewDeleteMaker(p).ByID(),
+			"UPDATE post SET `deleted_at`=NOW() WHERE `id`=? AND `deleted_at` IS NULL"},
+		{NewDeleteMaker(p).ByID().HardDelete(),
+			"DELETE FROM post WHERE `id`=?"},
+		{NewQueryMaker(p).Cond(NewPrepareCond().Eq("title", "hello")),
+			"SELECT `id`,`title` FROM post WHERE `title`=? AND `deleted_at` IS NULL"},
+		{NewQueryMaker(p).Count(),
+			"SELECT COUNT(1) FROM post WHERE `deleted_at` IS NULL"},
+		{NewQueryMaker(p).Unscoped(),
+			"SELECT `id`,`title` FROM post"},
+		{NewUpdateMaker(p).ByID(),
+			"UPDATE post SET `id`=?,`title`=? WHERE `id`=?"},
+	}
+	for _, c := range cases {
+		if s := c.maker.BuildMake(); s != c.expect {
+			t.Errorf("expect %s, got %s", c.expect, s)
+		}
+	}
+
+	// 通过SoftDeleter声明的字段不在"field"标签中，也不会被字段校验拒绝
+	maker := NewQueryMaker(Tag{}).Cond(Eq("id", 1)).StrictColumns()
+	if s, err := maker.Build().Make(); err != nil || s != "SELECT `id`,`name` FROM tag WHERE `id`=? AND `removed_at` IS NULL" {
+		t.Errorf("unexpected sql: %s, %v", s, err)
+	}
+}
+
+type Tag struct {
+	Id   int    `field:"id"`
+	Name string `field:"name"`
+}
+
+func (tag Tag) GetId() (string, interface{}) {
+	return "id", tag.Id
+}
+
+func (tag Tag) TableName() string {
+	return "tag"
+}
+
+func (tag Tag) SoftDeleteColumn() string {
+	return "removed_at"
+}
+
+func TestUnsafeStatement(t *testing.T) {
+
+	user := User{Id: 5, Name: "Mike"}
+	makers := []*SqlMaker{
+		NewUpdateMaker(user),
+		NewDeleteMaker(user),
+		NewDeleteMaker(user).Cond(NewPrepareCond()),
+		NewTableUpdateMaker("user").Set("status", 3),
+		NewDeleteMaker(Post{}),
+	}
+	for _, maker := range makers {
+		if _, err := maker.Build().Make(); !errors.Is(err, ErrUnsafeStatement) {
+			t.Errorf("expect ErrUnsafeStatement, got %v", err)
+		}
+		if _, err := maker.Exec(); !errors.Is(err, ErrUnsafeStatement) {
+			t.Errorf("expect ErrUnsafeStatement from Exec, got %v", err)
+		}
+	}
+
+	if s := NewDeleteMaker(user).AllowFullTable().BuildMake(); s != "DELETE FROM user" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+	if s := NewTableUpdateMaker("user").Set("status", 3).AllowFullTable().BuildMake(); s != "UPDATE user SET `status`=?" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+}
+
+type Comment struct {
+	Id         int       `field:"id"`
+	Content    string    `field:"content"`
+	CreateDate time.Time `field:"create_date,autoCreateTime"`
+	UpdateDate time.Time `field:"update_date,autoUpdateTime"`
+}
+
+func (c Comment) GetId() (string, interface{}) {
+	return "id", c.Id
+}
+
+func (c Comment) TableName() string {
+	return "comment"
+}
+
+func TestAutoTime(t *testing.T) {
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	SetClock(func() time.Time { return now })
+	defer SetClock(nil)
+
+	c := Comment{Id: 1, Content: "hi"}
+	maker := NewInsertMaker(&c).Prepare(false)
+	expect := "INSERT INTO comment(`id`,`content`,`create_date`,`update_date`) " +
+		"VALUES(1,'hi','2020-01-02 03:04:05','2020-01-02 03:04:05')"
+	if s := maker.BuildMake(); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+	if !c.CreateDate.Equal(now) || !c.UpdateDate.Equal(now) {
+		t.Errorf("timestamps are not written back: %v %v", c.CreateDate, c.UpdateDate)
+	}
+
+	// 已经有值的创建时间不会被覆盖
+	created := now.Add(-time.Hour)
+	maker = NewInsertMaker(Comment{Id: 2, CreateDate: created})
+	maker.BuildMake()
+	if fmt.Sprint(maker.Values()) != fmt.Sprint([]interface{}{2, "", created, now}) {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+
+	// 更新语句即使通过Filter过滤掉了更新时间，也会刷新更新时间
+	now = now.Add(time.Minute)
+	maker = NewUpdateMaker(&c).ByID().Filter("content")
+	if s := maker.BuildMake(); s != "UPDATE comment SET `content`=?,`update_date`=? WHERE `id`=?" {
+		t.Errorf("unexpected sql: %s", s)
+	}
+	if fmt.Sprint(maker.Values()) != fmt.Sprint([]interface{}{"hi", now, 1}) || !c.UpdateDate.Equal(now) {
+		t.Errorf("unexpected values: %v", maker.Values())
+	}
+
+	// 被追踪并且没有修改的entity不会刷新更新时间
+	Track(&c)
+	defer Untrack(&c)
+	now = now.Add(time.Minute)
+	if s := NewUpdateMaker(&c).ByID().BuildMake(); s != "" || c.UpdateDate.Equal(now) {
+		t.Errorf("unexpected sql: %s", s)
+	}
+}
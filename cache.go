@@ -0,0 +1,226 @@
+package sqlmaker
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// 每个db默认缓存的prepare语句数量
+const defaultStmtCacheSize = 256
+
+// prepare语句缓存。sqlmaker生成的SQL重复度非常高，如果每次执行都Prepare再Close，
+// 会使每个查询的网络往返次数翻倍。因此每个*sql.DB都有一个以SQL文本为key的LRU缓存
+var (
+	stmtCachesMu  sync.Mutex
+	stmtCaches    = make(map[*sql.DB]*stmtCache)
+	stmtCacheSize = defaultStmtCacheSize
+)
+
+// 设置每个db最多缓存多少个prepare语句，默认为256
+// size<=0表示关闭缓存，此时每次执行都会重新Prepare，执行完毕后Close
+// 该设置只对之后新建的缓存生效，已经存在的缓存会被清空
+func SetStmtCacheSize(size int) {
+	stmtCachesMu.Lock()
+	caches := stmtCaches
+	stmtCaches = make(map[*sql.DB]*stmtCache)
+	stmtCacheSize = size
+	stmtCachesMu.Unlock()
+
+	for _, cache := range caches {
+		cache.clear()
+	}
+}
+
+// 清空db对应的prepare语句缓存，关闭db前建议调用该函数释放所有stmt
+// 正在被使用的stmt会在使用完毕之后才被关闭
+func ClearStmtCache(db *sql.DB) {
+	stmtCachesMu.Lock()
+	cache, ok := stmtCaches[db]
+	delete(stmtCaches, db)
+	stmtCachesMu.Unlock()
+
+	if ok {
+		cache.clear()
+	}
+}
+
+// prepare语句缓存的统计数据
+type StmtCacheStats struct {
+	// 命中缓存的次数
+	Hits uint64
+
+	// 未命中缓存，需要重新Prepare的次数
+	Misses uint64
+
+	// 因为缓存已满被淘汰的stmt数量
+	Evictions uint64
+
+	// 当前缓存的stmt数量
+	Size int
+}
+
+// 返回db对应的prepare语句缓存的统计数据
+func GetStmtCacheStats(db *sql.DB) StmtCacheStats {
+	stmtCachesMu.Lock()
+	cache, ok := stmtCaches[db]
+	stmtCachesMu.Unlock()
+
+	if !ok {
+		return StmtCacheStats{}
+	}
+	return cache.stats()
+}
+
+// 返回db对应的缓存，如果缓存被关闭，返回nil
+func getStmtCache(db *sql.DB) *stmtCache {
+	stmtCachesMu.Lock()
+	defer stmtCachesMu.Unlock()
+
+	if stmtCacheSize <= 0 {
+		return nil
+	}
+	cache, ok := stmtCaches[db]
+	if !ok {
+		cache = newStmtCache(db, stmtCacheSize)
+		stmtCaches[db] = cache
+	}
+	return cache
+}
+
+// 被缓存的stmt
+// refs记录了当前正在使用该stmt的调用数，被淘汰的stmt要等到refs为0时才会真正关闭
+type cachedStmt struct {
+	stmt    *sql.Stmt
+	query   string
+	refs    int
+	evicted bool
+	elem    *list.Element
+}
+
+type stmtCache struct {
+	mu       sync.Mutex
+	db       *sql.DB
+	capacity int
+	items    map[string]*cachedStmt
+	lru      *list.List
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newStmtCache(db *sql.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		items:    make(map[string]*cachedStmt),
+		lru:      list.New(),
+	}
+}
+
+// 取得query对应的stmt，如果没有缓存则Prepare一个新的
+// 使用完毕之后必须调用release归还，Prepare时使用ctx，从而遵守执行SQL时的超时和取消
+func (cache *stmtCache) acquire(ctx context.Context, query string) (*cachedStmt, error) {
+
+	cache.mu.Lock()
+	if cs, ok := cache.items[query]; ok {
+		cs.refs++
+		cache.lru.MoveToFront(cs.elem)
+		cache.hits++
+		cache.mu.Unlock()
+		return cs, nil
+	}
+	cache.misses++
+	cache.mu.Unlock()
+
+	// Prepare需要访问数据库，不能持有锁
+	stmt, err := cache.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	// 可能有其它调用同时Prepare了同一个语句，使用先放入缓存的那个
+	if cs, ok := cache.items[query]; ok {
+		cs.refs++
+		cache.lru.MoveToFront(cs.elem)
+		cache.mu.Unlock()
+		safeClose(stmt)
+		return cs, nil
+	}
+
+	cs := &cachedStmt{
+		stmt:  stmt,
+		query: query,
+		refs:  1,
+	}
+	cs.elem = cache.lru.PushFront(cs)
+	cache.items[query] = cs
+
+	closing := make([]*sql.Stmt, 0)
+	for cache.lru.Len() > cache.capacity {
+		oldest := cache.lru.Back().Value.(*cachedStmt)
+		if stmt := cache.evict(oldest); stmt != nil {
+			closing = append(closing, stmt)
+		}
+		cache.evictions++
+	}
+	cache.mu.Unlock()
+
+	for _, stmt := range closing {
+		safeClose(stmt)
+	}
+	return cs, nil
+}
+
+// 归还通过acquire取得的stmt，如果stmt已经被淘汰并且没有其它调用在使用，则关闭它
+func (cache *stmtCache) release(cs *cachedStmt) {
+	cache.mu.Lock()
+	cs.refs--
+	closable := cs.evicted && cs.refs == 0
+	cache.mu.Unlock()
+
+	if closable {
+		safeClose(cs.stmt)
+	}
+}
+
+// 将stmt移出缓存，如果stmt没有被使用，返回需要关闭的stmt，调用者需要在释放锁之后关闭
+func (cache *stmtCache) evict(cs *cachedStmt) *sql.Stmt {
+	cache.lru.Remove(cs.elem)
+	delete(cache.items, cs.query)
+	cs.evicted = true
+	if cs.refs == 0 {
+		return cs.stmt
+	}
+	return nil
+}
+
+// 淘汰所有stmt
+func (cache *stmtCache) clear() {
+	cache.mu.Lock()
+	closing := make([]*sql.Stmt, 0)
+	for _, cs := range cache.items {
+		if stmt := cache.evict(cs); stmt != nil {
+			closing = append(closing, stmt)
+		}
+	}
+	cache.mu.Unlock()
+
+	for _, stmt := range closing {
+		safeClose(stmt)
+	}
+}
+
+func (cache *stmtCache) stats() StmtCacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return StmtCacheStats{
+		Hits:      cache.hits,
+		Misses:    cache.misses,
+		Evictions: cache.evictions,
+		Size:      cache.lru.Len(),
+	}
+}
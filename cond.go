@@ -1,41 +1,39 @@
 package sqlmaker
 
-import (
-	"fmt"
-	"strconv"
-	"strings"
-	"time"
-)
+import "strings"
 
-// 所有的条件表达式格式，%s会被替换为具体的值
-// {}表示下一个表达式出现的位置
+// 条件连接符
 const (
-	_EQ     = "%s=%s {}"
-	_NOTEQ  = "%s!=%s {}"
-	_AND    = "AND {}"
-	_ANDALL = "AND ( {})"
-	_OR     = "OR {}"
-	_ORALL  = "OR ( {})"
-	_LT     = "%s>%s {}"
-	_ST     = "%s<%s {}"
-	_LTEQ   = "%s>=%s {}"
-	_STEQ   = "%s<=%s {}"
-	_IN     = "%s IN (%s) {}"
-	_NOTIN  = "%s NOT IN (%s) {}"
-	_LIKE   = "%s LIKE %s"
-	_ENDALL = "endall"
+	_AND = "AND"
+	_OR  = "OR"
 )
 
 // 用于构建条件表达式
 // 表达式由很多条件和条件连接符组成，通过调用该结构体的函数可以
-// 顺序组织这些条件和条件连接符，最终生成SQL识别的条件表达式
+// 顺序组织这些条件和条件连接符，它们会被组织为一棵语法树(见Node)，最终生成SQL识别的条件表达式
 type Cond struct {
 
-	// 所有的条件集合
-	ops []string
+	// 正在构建的括号层级，frames[0]为最外层，每次调用AndAll/OrAll都会新增一层，EndAll会关闭最内层
+	frames []*condFrame
 
-	// 表达式的所有值，在需要prepare的时候使用
-	values []interface{}
+	// 是否为prepare表达式，prepare表达式的值会被渲染为"?"
+	prepare bool
+}
+
+// 一层括号中的条件
+type condFrame struct {
+
+	// 已经加入的条件
+	nodes []Node
+
+	// 每个条件前面的连接符，第一个条件的连接符没有意义
+	conns []string
+
+	// 下一个条件前面的连接符，为空时默认为AND
+	pending string
+
+	// 这一层括号在上一层中的连接符
+	conn string
 }
 
 // 新建一个空的条件表达式(不再建议使用)
@@ -43,8 +41,8 @@ type Cond struct {
 // Deprecated: 请使用NewPrepareCond函数作为替代
 func NewCond() *Cond {
 	return &Cond{
-		ops:    make([]string, 0),
-		values: nil,
+		frames:  []*condFrame{{}},
+		prepare: false,
 	}
 }
 
@@ -52,144 +50,281 @@ func NewCond() *Cond {
 // 这样生成的表达式的value将会为?，可以通过Cond.Values取得对应的值
 func NewPrepareCond() *Cond {
 	return &Cond{
-		ops:    make([]string, 0),
-		values: make([]interface{}, 0),
+		frames:  []*condFrame{{}},
+		prepare: true,
 	}
 }
 
 // 新增一个相等条件
 func (cond *Cond) Eq(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_EQ, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, "=", v)
 }
 
 // 新增一个不相等条件
 func (cond *Cond) NotEq(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_NOTEQ, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, "!=", v)
 }
 
 // 新增一个AND条件连接符
 func (cond *Cond) And() *Cond {
-	cond.ops = append(cond.ops, _AND)
+	cond.top().pending = _AND
 	return cond
 }
 
 // 新增一个AND条件连接符和一对括号，接下来的条件都会在括号中
 func (cond *Cond) AndAll() *Cond {
-	cond.ops = append(cond.ops, _ANDALL)
-	return cond
+	return cond.openGroup(_AND)
 }
 
 // 新增一个LIKE条件
 func (cond *Cond) Like(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_LIKE, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, "LIKE", v)
+}
+
+// 新增一个NOT LIKE条件
+func (cond *Cond) NotLike(k string, v interface{}) *Cond {
+	return cond.compare(k, "NOT LIKE", v)
+}
+
+// 新增一个忽略大小写的LIKE条件，生成LOWER(k) LIKE LOWER(v)
+func (cond *Cond) ILike(k string, v interface{}) *Cond {
+	return cond.add(&CompareNode{Column: k, Op: "LIKE", Value: v, IgnoreCase: true})
+}
+
+// 新增一个包含条件，生成k LIKE '%s%'，s中的"%"、"_"和"\"会被转义，只会按照字面匹配
+func (cond *Cond) Contains(k string, s string) *Cond {
+	return cond.Like(k, "%"+escapeLike(s)+"%")
+}
+
+// 新增一个前缀条件，生成k LIKE 's%'，s中的通配符会被转义
+func (cond *Cond) StartsWith(k string, s string) *Cond {
+	return cond.Like(k, escapeLike(s)+"%")
+}
+
+// 新增一个后缀条件，生成k LIKE '%s'，s中的通配符会被转义
+func (cond *Cond) EndsWith(k string, s string) *Cond {
+	return cond.Like(k, "%"+escapeLike(s))
+}
+
+// 新增一个正则匹配条件
+func (cond *Cond) Regexp(k string, pattern string) *Cond {
+	return cond.compare(k, "REGEXP", pattern)
+}
+
+// 新增一个BETWEEN条件，low和high都包含在范围内
+func (cond *Cond) Between(k string, low, high interface{}) *Cond {
+	return cond.add(&BetweenNode{Column: k, Low: low, High: high})
+}
+
+// 新增一个NOT BETWEEN条件
+func (cond *Cond) NotBetween(k string, low, high interface{}) *Cond {
+	return cond.add(&BetweenNode{Column: k, Low: low, High: high, Not: true})
+}
+
+// 新增一个IS NULL条件
+func (cond *Cond) IsNull(k string) *Cond {
+	return cond.add(&NullNode{Column: k})
+}
+
+// 新增一个IS NOT NULL条件
+func (cond *Cond) IsNotNull(k string) *Cond {
+	return cond.add(&NullNode{Column: k, Not: true})
 }
 
 // 新增一个OR条件连接符
 func (cond *Cond) Or() *Cond {
-	cond.ops = append(cond.ops, _OR)
+	cond.top().pending = _OR
 	return cond
 }
 
 // 新增一个OR条件连接符和一对括号，接下来的条件都会在括号中
 func (cond *Cond) OrAll() *Cond {
-	cond.ops = append(cond.ops, _ORALL)
-	return cond
+	return cond.openGroup(_OR)
 }
 
 // 跳出当前括号，接下来的条件会从括号后面开始
 func (cond *Cond) EndAll() *Cond {
-	cond.ops = append(cond.ops, _ENDALL)
+	if len(cond.frames) == 1 {
+		return cond
+	}
+	frame := cond.top()
+	cond.frames = cond.frames[:len(cond.frames)-1]
+	if node := frame.fold(); node != nil {
+		cond.top().pending = frame.conn
+		cond.add(&GroupNode{Child: node})
+	}
 	return cond
 }
 
 // 新增一个大于条件
 func (cond *Cond) Lt(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_LT, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, ">", v)
 }
 
 // 新增一个小于条件
 func (cond *Cond) St(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_ST, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, "<", v)
 }
 
 // 新增一个大于等于条件
 func (cond *Cond) LtEq(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_LTEQ, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, ">=", v)
 }
 
 // 新增一个小于等于条件
 func (cond *Cond) StEq(k string, v interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_STEQ, k, cond.getVal(v)))
-	return cond
+	return cond.compare(k, "<=", v)
 }
 
-// 新增一个IN条件
-func (cond *Cond) In(k string, vs []interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_IN, k, cond.getManyVal(vs)))
-	return cond
+// 新增一个IN条件，vs可以是任意类型的切片，例如[]int、[]string、[]interface{}
+// vs为空时生成永远为假的条件"1=0"，列表很大时可以通过SetInChunkSize分组
+func (cond *Cond) In(k string, vs interface{}) *Cond {
+	return cond.add(&InNode{Column: k, Values: sliceValues(vs)})
 }
 
-// 新增一个NOT IN条件
-func (cond *Cond) NotIn(k string, vs []interface{}) *Cond {
-	cond.ops = append(cond.ops, fmt.Sprintf(_NOTIN, k, cond.getManyVal(vs)))
-	return cond
+// 新增一个NOT IN条件，vs和In一样可以是任意类型的切片
+// vs为空时生成永远为真的条件"1=1"
+func (cond *Cond) NotIn(k string, vs interface{}) *Cond {
+	return cond.add(&InNode{Column: k, Values: sliceValues(vs), Not: true})
 }
 
-// 如果使用的是prepare表达式，返回所有"?"替换符对应的值
+// 如果使用的是prepare表达式，返回所有"?"替换符对应的值，顺序和它们在表达式中出现的顺序一致
+// 如果不是prepare表达式，返回nil
 func (cond *Cond) Values() []interface{} {
-	return cond.values
+	_, values := renderNode(cond.Node(), cond.prepare)
+	return values
 }
 
 // 根据所有增加的条件生成条件表达式
+// 该函数不会修改表达式，可以重复调用，之后也可以继续增加条件
 func (cond *Cond) Make() string {
-	if len(cond.ops) == 0 {
-		return ""
+	s, _ := renderNode(cond.Node(), cond.prepare)
+	return s
+}
+
+// 返回表达式对应的语法树，没有任何条件时返回nil
+// 没有通过EndAll关闭的括号会被自动关闭
+func (cond *Cond) Node() Node {
+	var (
+		inner     Node
+		innerConn string
+	)
+	for i := len(cond.frames) - 1; i >= 0; i-- {
+		frame := *cond.frames[i]
+		if inner != nil {
+			// 复制切片，避免修改正在构建的表达式
+			frame.nodes = append(frame.nodes[:len(frame.nodes):len(frame.nodes)], inner)
+			frame.conns = append(frame.conns[:len(frame.conns):len(frame.conns)], innerConn)
+		}
+		node := frame.fold()
+		if i == 0 {
+			return node
+		}
+		inner, innerConn = nil, frame.conn
+		if node != nil {
+			inner = &GroupNode{Child: node}
+		}
 	}
+	return nil
+}
+
+// 表达式是否为空，即没有增加任何条件
+func (cond *Cond) Empty() bool {
+	return cond.Node() == nil
+}
 
-	cond.ops = append(cond.ops, "")
+// 前序遍历表达式的语法树，见Walk
+func (cond *Cond) Walk(fn func(Node) bool) {
+	Walk(cond.Node(), fn)
+}
 
-	res := "{}"
-	for _, op := range cond.ops {
-		if op == _ENDALL {
-			res = strings.ReplaceAll(res, "{})", ") {}")
-		} else {
-			res = strings.ReplaceAll(res, "{}", op)
+// 表达式中出现的所有字段名，按照出现的顺序排列
+func (cond *Cond) Columns() []string {
+	columns := make([]string, 0)
+	cond.Walk(func(node Node) bool {
+		switch n := node.(type) {
+		case *CompareNode:
+			columns = append(columns, n.Column)
+		case *InNode:
+			columns = append(columns, n.Column)
+		case *BetweenNode:
+			columns = append(columns, n.Column)
+		case *NullNode:
+			columns = append(columns, n.Column)
 		}
-	}
+		return true
+	})
+	return columns
+}
 
-	return strings.Trim(res, " ")
+// 新增一个比较条件
+func (cond *Cond) compare(k, op string, v interface{}) *Cond {
+	return cond.add(&CompareNode{Column: k, Op: op, Value: v})
 }
 
-func (cond *Cond) getManyVal(vs []interface{}) string {
-	vals := make([]string, len(vs))
-	for _, v := range vs {
-		vals = append(vals, cond.getVal(v))
+// 将条件加入当前括号中
+func (cond *Cond) add(node Node) *Cond {
+	frame := cond.top()
+	conn := frame.pending
+	if conn == "" {
+		conn = _AND
 	}
-	return strings.Join(vals, ",")
+	frame.nodes = append(frame.nodes, node)
+	frame.conns = append(frame.conns, conn)
+	frame.pending = ""
+	return cond
+}
+
+// 新增一层括号，conn为括号在当前层中的连接符
+func (cond *Cond) openGroup(conn string) *Cond {
+	cond.top().pending = ""
+	cond.frames = append(cond.frames, &condFrame{conn: conn})
+	return cond
+}
+
+// 当前所在的括号
+func (cond *Cond) top() *condFrame {
+	return cond.frames[len(cond.frames)-1]
 }
 
-func (cond *Cond) getVal(v interface{}) string {
+// 将一层括号中的条件按照SQL的优先级组织为语法树：AND的优先级高于OR，
+// 因此"a AND b OR c"会被组织为Or(And(a, b), c)
+func (frame *condFrame) fold() Node {
+	if len(frame.nodes) == 0 {
+		return nil
+	}
 
-	if cond.values != nil {
-		cond.values = append(cond.values, v)
-		return "?"
+	ors := make([]Node, 0)
+	ands := make([]Node, 0)
+	for i, node := range frame.nodes {
+		if i > 0 && frame.conns[i] == _OR {
+			ors = append(ors, andOf(ands))
+			ands = make([]Node, 0)
+		}
+		ands = append(ands, node)
 	}
+	ors = append(ors, andOf(ands))
 
-	switch v.(type) {
-	case string:
-		return stringValue(v.(string))
-	case int:
-		return strconv.Itoa(v.(int))
-	case time.Time:
-		return dateToString(v.(time.Time))
-	default:
-		return stringValue(fmt.Sprintf("%v", v))
+	if len(ors) == 1 {
+		return ors[0]
 	}
+	return &OrNode{Children: ors}
+}
+
+// LIKE通配符的转义
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
 
+// 转义s中的LIKE通配符，使其只按照字面匹配
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func andOf(nodes []Node) Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &AndNode{Children: nodes}
 }
@@ -0,0 +1,162 @@
+package sqlmaker
+
+// 函数式的条件构建方式，可以直接组合出带括号的条件，而不需要手动配对AndAll/OrAll和EndAll
+// 例如 And(Eq("a", 1), Or(Eq("b", 2), Eq("c", 3))) 会生成 `a`=? AND (`b`=? OR `c`=?)
+// 这些函数生成的都是prepare表达式，空的条件(没有增加任何条件的Cond或nil)会被自动忽略
+
+// 用AND连接多个条件
+func And(conds ...*Cond) *Cond {
+	children := make([]Node, 0, len(conds))
+	for _, node := range condNodes(conds) {
+		// 嵌套的AND可以直接展开
+		if and, ok := node.(*AndNode); ok {
+			children = append(children, and.Children...)
+		} else {
+			children = append(children, node)
+		}
+	}
+	return condOf(andOf(children))
+}
+
+// 用OR连接多个条件
+func Or(conds ...*Cond) *Cond {
+	children := make([]Node, 0, len(conds))
+	for _, node := range condNodes(conds) {
+		if or, ok := node.(*OrNode); ok {
+			children = append(children, or.Children...)
+		} else {
+			children = append(children, node)
+		}
+	}
+	if len(children) <= 1 {
+		return condOf(andOf(children))
+	}
+	return condOf(&OrNode{Children: children})
+}
+
+// 条件取反
+func Not(cond *Cond) *Cond {
+	nodes := condNodes([]*Cond{cond})
+	if len(nodes) == 0 {
+		return NewPrepareCond()
+	}
+	return condOf(&NotNode{Child: nodes[0]})
+}
+
+// 相等条件
+func Eq(k string, v interface{}) *Cond {
+	return NewPrepareCond().Eq(k, v)
+}
+
+// 不相等条件
+func NotEq(k string, v interface{}) *Cond {
+	return NewPrepareCond().NotEq(k, v)
+}
+
+// 大于条件
+func Lt(k string, v interface{}) *Cond {
+	return NewPrepareCond().Lt(k, v)
+}
+
+// 小于条件
+func St(k string, v interface{}) *Cond {
+	return NewPrepareCond().St(k, v)
+}
+
+// 大于等于条件
+func LtEq(k string, v interface{}) *Cond {
+	return NewPrepareCond().LtEq(k, v)
+}
+
+// 小于等于条件
+func StEq(k string, v interface{}) *Cond {
+	return NewPrepareCond().StEq(k, v)
+}
+
+// LIKE条件
+func Like(k string, v interface{}) *Cond {
+	return NewPrepareCond().Like(k, v)
+}
+
+// NOT LIKE条件
+func NotLike(k string, v interface{}) *Cond {
+	return NewPrepareCond().NotLike(k, v)
+}
+
+// 忽略大小写的LIKE条件
+func ILike(k string, v interface{}) *Cond {
+	return NewPrepareCond().ILike(k, v)
+}
+
+// 包含条件，s中的通配符会被转义
+func Contains(k string, s string) *Cond {
+	return NewPrepareCond().Contains(k, s)
+}
+
+// 前缀条件，s中的通配符会被转义
+func StartsWith(k string, s string) *Cond {
+	return NewPrepareCond().StartsWith(k, s)
+}
+
+// 后缀条件，s中的通配符会被转义
+func EndsWith(k string, s string) *Cond {
+	return NewPrepareCond().EndsWith(k, s)
+}
+
+// 正则匹配条件
+func Regexp(k string, pattern string) *Cond {
+	return NewPrepareCond().Regexp(k, pattern)
+}
+
+// BETWEEN条件
+func Between(k string, low, high interface{}) *Cond {
+	return NewPrepareCond().Between(k, low, high)
+}
+
+// NOT BETWEEN条件
+func NotBetween(k string, low, high interface{}) *Cond {
+	return NewPrepareCond().NotBetween(k, low, high)
+}
+
+// IS NULL条件
+func IsNull(k string) *Cond {
+	return NewPrepareCond().IsNull(k)
+}
+
+// IS NOT NULL条件
+func IsNotNull(k string) *Cond {
+	return NewPrepareCond().IsNotNull(k)
+}
+
+// IN条件，vs可以是任意类型的切片
+func In(k string, vs interface{}) *Cond {
+	return NewPrepareCond().In(k, vs)
+}
+
+// NOT IN条件，vs可以是任意类型的切片
+func NotIn(k string, vs interface{}) *Cond {
+	return NewPrepareCond().NotIn(k, vs)
+}
+
+// 返回所有非空条件的语法树
+func condNodes(conds []*Cond) []Node {
+	nodes := make([]Node, 0, len(conds))
+	for _, cond := range conds {
+		if cond == nil {
+			continue
+		}
+		if node := cond.Node(); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 使用语法树新建一个prepare表达式，之后仍然可以继续通过链式调用增加条件
+func condOf(node Node) *Cond {
+	cond := NewPrepareCond()
+	if node != nil {
+		cond.add(node)
+	}
+	return cond
+}
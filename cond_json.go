@@ -0,0 +1,307 @@
+package sqlmaker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Cond的JSON格式，语法树的每个节点都是一个对象，例如`age`>?会被序列化为：
+// {"op":"compare","column":"age","operator":">","value":{"type":"int","value":18}}
+// 值会带上类型，因此反序列化之后得到的Cond和原来的Cond生成的SQL相同，Values()等价：
+// int、int64、float64、string、bool、[]byte和UTC时间会被原样恢复，其它整数类型会被恢复为int64或uint64，
+// float32会被恢复为float64，非UTC的时间会被恢复为相同时刻、相同偏移量的固定时区时间
+type condJSON struct {
+	Prepare bool      `json:"prepare"`
+	Where   *nodeJSON `json:"where"`
+}
+
+type nodeJSON struct {
+	Op         string       `json:"op"`
+	Column     string       `json:"column,omitempty"`
+	Operator   string       `json:"operator,omitempty"`
+	IgnoreCase bool         `json:"ignoreCase,omitempty"`
+	Not        bool         `json:"not,omitempty"`
+	Value      *valueJSON   `json:"value,omitempty"`
+	Values     []*valueJSON `json:"values,omitempty"`
+	Low        *valueJSON   `json:"low,omitempty"`
+	High       *valueJSON   `json:"high,omitempty"`
+	Child      *nodeJSON    `json:"child,omitempty"`
+	Children   []*nodeJSON  `json:"children,omitempty"`
+}
+
+type valueJSON struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// 将条件表达式序列化为JSON
+func (cond *Cond) MarshalJSON() ([]byte, error) {
+	where, err := encodeNode(cond.Node())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(condJSON{
+		Prepare: cond.prepare,
+		Where:   where,
+	})
+}
+
+// 从MarshalJSON生成的JSON中恢复条件表达式
+func (cond *Cond) UnmarshalJSON(data []byte) error {
+	var c condJSON
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	node, err := decodeNode(c.Where)
+	if err != nil {
+		return err
+	}
+
+	*cond = Cond{
+		frames:  []*condFrame{{}},
+		prepare: c.Prepare,
+	}
+	if node != nil {
+		cond.add(node)
+	}
+	return nil
+}
+
+func encodeNode(node Node) (*nodeJSON, error) {
+	var err error
+	switch n := node.(type) {
+	case nil:
+		return nil, nil
+	case *AndNode:
+		j := &nodeJSON{Op: "and"}
+		j.Children, err = encodeNodes(n.Children)
+		return j, err
+	case *OrNode:
+		j := &nodeJSON{Op: "or"}
+		j.Children, err = encodeNodes(n.Children)
+		return j, err
+	case *NotNode:
+		j := &nodeJSON{Op: "not"}
+		j.Child, err = encodeNode(n.Child)
+		return j, err
+	case *GroupNode:
+		j := &nodeJSON{Op: "group"}
+		j.Child, err = encodeNode(n.Child)
+		return j, err
+	case *CompareNode:
+		j := &nodeJSON{Op: "compare", Column: n.Column, Operator: n.Op, IgnoreCase: n.IgnoreCase}
+		j.Value, err = encodeValue(n.Value)
+		return j, err
+	case *InNode:
+		j := &nodeJSON{Op: "in", Column: n.Column, Not: n.Not, Values: make([]*valueJSON, 0, len(n.Values))}
+		for _, v := range n.Values {
+			value, err := encodeValue(v)
+			if err != nil {
+				return nil, err
+			}
+			j.Values = append(j.Values, value)
+		}
+		return j, nil
+	case *BetweenNode:
+		j := &nodeJSON{Op: "between", Column: n.Column, Not: n.Not}
+		if j.Low, err = encodeValue(n.Low); err != nil {
+			return nil, err
+		}
+		j.High, err = encodeValue(n.High)
+		return j, err
+	case *NullNode:
+		return &nodeJSON{Op: "null", Column: n.Column, Not: n.Not}, nil
+	default:
+		return nil, fmt.Errorf("can not marshal node %T", node)
+	}
+}
+
+func encodeNodes(nodes []Node) ([]*nodeJSON, error) {
+	js := make([]*nodeJSON, 0, len(nodes))
+	for _, node := range nodes {
+		j, err := encodeNode(node)
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, j)
+	}
+	return js, nil
+}
+
+// Cond会生成的所有比较运算符，CompareNode.Op会被原样拼接进SQL，因此反序列化时只接受这些运算符
+var compareOps = map[string]bool{
+	"=": true, "!=": true, ">": true, "<": true, ">=": true, "<=": true,
+	"LIKE": true, "NOT LIKE": true, "REGEXP": true,
+}
+
+func decodeNode(j *nodeJSON) (Node, error) {
+	if j == nil {
+		return nil, nil
+	}
+	var err error
+	switch j.Op {
+	case "and", "or":
+		if len(j.Children) == 0 {
+			return nil, errors.New("no children in " + j.Op)
+		}
+		children := make([]Node, 0, len(j.Children))
+		for _, c := range j.Children {
+			child, err := decodeNode(c)
+			if err != nil {
+				return nil, err
+			}
+			if child == nil {
+				return nil, errors.New("empty child in " + j.Op)
+			}
+			children = append(children, child)
+		}
+		if j.Op == "and" {
+			return &AndNode{Children: children}, nil
+		}
+		return &OrNode{Children: children}, nil
+	case "not", "group":
+		child, err := decodeNode(j.Child)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			return nil, errors.New("empty child in " + j.Op)
+		}
+		if j.Op == "not" {
+			return &NotNode{Child: child}, nil
+		}
+		return &GroupNode{Child: child}, nil
+	case "compare":
+		if !compareOps[j.Operator] {
+			return nil, fmt.Errorf("unknown compare operator %q", j.Operator)
+		}
+		n := &CompareNode{Column: j.Column, Op: j.Operator, IgnoreCase: j.IgnoreCase}
+		n.Value, err = decodeValue(j.Value)
+		return n, err
+	case "in":
+		n := &InNode{Column: j.Column, Not: j.Not, Values: make([]interface{}, 0, len(j.Values))}
+		for _, value := range j.Values {
+			v, err := decodeValue(value)
+			if err != nil {
+				return nil, err
+			}
+			n.Values = append(n.Values, v)
+		}
+		return n, nil
+	case "between":
+		n := &BetweenNode{Column: j.Column, Not: j.Not}
+		if n.Low, err = decodeValue(j.Low); err != nil {
+			return nil, err
+		}
+		n.High, err = decodeValue(j.High)
+		return n, err
+	case "null":
+		return &NullNode{Column: j.Column, Not: j.Not}, nil
+	default:
+		return nil, fmt.Errorf("unknown node op %q", j.Op)
+	}
+}
+
+// 将值编码为带有类型的JSON
+func encodeValue(v interface{}) (*valueJSON, error) {
+	var (
+		typ string
+		raw interface{}
+	)
+	switch val := v.(type) {
+	case nil:
+		return &valueJSON{Type: "null"}, nil
+	case string:
+		typ, raw = "string", val
+	case int:
+		typ, raw = "int", val
+	case int64:
+		// int64可能超出JSON数字的精度范围，使用字符串保存
+		typ, raw = "int64", strconv.FormatInt(val, 10)
+	case float64:
+		typ, raw = "float64", val
+	case bool:
+		typ, raw = "bool", val
+	case time.Time:
+		typ, raw = "time", val.Format(time.RFC3339Nano)
+	case []byte:
+		typ, raw = "bytes", base64.StdEncoding.EncodeToString(val)
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32:
+			return encodeValue(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			typ, raw = "uint64", strconv.FormatUint(rv.Uint(), 10)
+		case reflect.Float32:
+			return encodeValue(rv.Float())
+		case reflect.String:
+			return encodeValue(rv.String())
+		default:
+			return nil, fmt.Errorf("can not marshal value of type %T", v)
+		}
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &valueJSON{Type: typ, Value: data}, nil
+}
+
+// 从带有类型的JSON中解码值
+func decodeValue(j *valueJSON) (interface{}, error) {
+	if j == nil || j.Type == "null" {
+		return nil, nil
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	switch j.Type {
+	case "string":
+		var s string
+		err = json.Unmarshal(j.Value, &s)
+		v = s
+	case "int":
+		var i int
+		err = json.Unmarshal(j.Value, &i)
+		v = i
+	case "float64":
+		var f float64
+		err = json.Unmarshal(j.Value, &f)
+		v = f
+	case "bool":
+		var b bool
+		err = json.Unmarshal(j.Value, &b)
+		v = b
+	case "int64", "uint64", "time", "bytes":
+		var s string
+		if err = json.Unmarshal(j.Value, &s); err != nil {
+			break
+		}
+		switch j.Type {
+		case "int64":
+			v, err = strconv.ParseInt(s, 10, 64)
+		case "uint64":
+			v, err = strconv.ParseUint(s, 10, 64)
+		case "time":
+			v, err = time.Parse(time.RFC3339Nano, s)
+		case "bytes":
+			v, err = base64.StdEncoding.DecodeString(s)
+		}
+	default:
+		return nil, fmt.Errorf("unknown value type %q", j.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", j.Type, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,102 @@
+package sqlmaker
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL方言，负责将名称和值转换为可以直接拼接进SQL的文本
+type Dialect interface {
+	// 返回引用后的名称，例如MySQL中的`name`
+	Quote(name string) string
+
+	// 将值转换为SQL字面量，例如字符串会被转义并用单引号包裹，nil会转换为NULL
+	Literal(v interface{}) string
+}
+
+// MySQL方言，这也是默认的方言
+var MySQL Dialect = mysqlDialect{}
+
+var dialect = MySQL
+
+// 设置全局使用的SQL方言，默认为MySQL
+func SetDialect(d Dialect) {
+	dialect = d
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d mysqlDialect) Literal(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return mysqlQuoteString(val)
+	case []byte:
+		if val == nil {
+			return "NULL"
+		}
+		return "X'" + hex.EncodeToString(val) + "'"
+	case time.Time:
+		return mysqlQuoteString(val.Format(datetimeFormat))
+	case bool:
+		if val {
+			return "1"
+		}
+		return "0"
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case driver.Valuer:
+		dv, err := val.Value()
+		if err != nil {
+			return "NULL"
+		}
+		return d.Literal(dv)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return "NULL"
+		}
+		return d.Literal(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+	case reflect.String:
+		return mysqlQuoteString(rv.String())
+	}
+	return mysqlQuoteString(fmt.Sprintf("%v", v))
+}
+
+// MySQL字符串字面量中需要转义的字符，和mysql_real_escape_string一致
+var mysqlEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+func mysqlQuoteString(s string) string {
+	return "'" + mysqlEscaper.Replace(s) + "'"
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,6 +13,7 @@ const datetimeFormat = "2006-01-02 15:04:05"
 
 // 要想使用sqlmaker生成某个结构体的SQL语句，则该结构体必须实现该接口
 // 另外，每个字段需要使用标签"field"来指定其在数据表中的字段名称
+// 字段名之后可以用逗号分隔跟上选项，例如`field:"version,version"`，支持的选项见tagVersion等常量
 type Entity interface {
 	// 返回结构体在数据库中对应的表名
 	TableName() string
@@ -21,38 +24,83 @@ type Entity interface {
 	GetId() (string, interface{})
 }
 
+// "field"标签支持的选项
+const (
+	// 乐观锁版本号字段，更新时会自动增加"AND version=?"条件并SET version=version+1
+	tagVersion = "version"
+
+	// 软删除字段，删除语句会被改写为SET该字段为当前时间，查询时会自动增加"该字段 IS NULL"条件
+	tagSoftDelete = "softDelete"
+
+	// 创建时间字段，新建语句会自动填充为当前时间(见SetClock)，已经有值时不会覆盖
+	tagAutoCreateTime = "autoCreateTime"
+
+	// 更新时间字段，新建和更新语句都会自动填充为当前时间，即使更新语句通过Filter过滤掉了该字段
+	tagAutoUpdateTime = "autoUpdateTime"
+)
+
+// entity可以实现该接口来声明软删除字段，作用和"field"标签中的softDelete选项一样
+// 当字段名不方便写在标签中时(例如来自嵌入的公共结构体)，可以使用该接口
+type SoftDeleter interface {
+	// 返回软删除字段在数据表中的字段名
+	SoftDeleteColumn() string
+}
+
+// "field"标签中字段名之后的选项
+type tagOptions []string
+
+// 是否包含选项opt
+func (opts tagOptions) has(opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析属性的"field"标签，返回数据表字段名和选项
+// 没有标签或者标签的字段名为"-"时，字段名和属性名一致
+func parseTag(field reflect.StructField) (string, tagOptions) {
+	parts := strings.Split(field.Tag.Get("field"), ",")
+	name := parts[0]
+	if name == "" || name == "-" {
+		name = field.Name
+	}
+	return name, tagOptions(parts[1:])
+}
+
 // 字段结构体
 // Name: 字段在结构体中的名称
 // TableFieldName: 字段在数据表中的名称，需要通过字段标签"field"指定，如果不指定，则和Name一致
-// val: 字段的string值(如果是字符串，会加上单引号包裹)
+// val: 字段的SQL字面量(如果是字符串，会被转义并加上单引号包裹)
 // originVal: 字段的真正具体值
+// opts: 字段标签中的选项
 type Field struct {
 	Name           string
 	TableFieldName string
 	val            string
 	originVal      interface{}
+	opts           tagOptions
 }
 
-// 将一个Entity的所有字段解析出来，返回一个field列表
+// 将一个Entity的所有字段解析出来，返回一个field列表，o可以是结构体或结构体指针
 func decodeEntity(o interface{}, selects []string) []Field {
 
 	fields := make([]Field, 0)
 
-	vs := reflect.ValueOf(o)
+	vs := reflect.Indirect(reflect.ValueOf(o))
 
 	for i := 0; i < vs.NumField(); i++ {
 
 		field := vs.Type().Field(i)
-		tag := field.Tag.Get("field")
+		tag, opts := parseTag(field)
 
 		if !contains(field.Name, tag, selects) {
 			continue
 		}
 
 		originVal := vs.Field(i).Interface()
-		if tag == "" || tag == "-" {
-			tag = field.Name
-		}
 
 		val := ""
 		switch field.Type.Name() {
@@ -70,6 +118,7 @@ func decodeEntity(o interface{}, selects []string) []Field {
 				TableFieldName: tag,
 				val:            val,
 				originVal:      originVal,
+				opts:           opts,
 			}
 			fields = append(fields, fieldObj)
 		}
@@ -78,8 +127,73 @@ func decodeEntity(o interface{}, selects []string) []Field {
 	return fields
 }
 
+// 结构体属性名到属性下标的映射缓存，key为结构体的reflect.Type
+// 解码查询结果时会频繁根据属性名查找字段，缓存后同一类型只需要解析一次
+var fieldIndexCache sync.Map
+
+// 返回结构体类型t的属性名到属性下标的映射，结果会被缓存
+func fieldIndexes(t reflect.Type) map[string]int {
+	if cached, ok := fieldIndexCache.Load(t); ok {
+		return cached.(map[string]int)
+	}
+	indexes := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		indexes[t.Field(i).Name] = i
+	}
+	fieldIndexCache.Store(t, indexes)
+	return indexes
+}
+
+// 返回结构体类型t在数据表中的所有字段名，即每个属性的"field"标签，没有标签时为属性名
+// t可以是结构体指针类型
+func tableFieldNames(t reflect.Type) map[string]bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _ := parseTag(t.Field(i))
+		names[name] = true
+	}
+	return names
+}
+
+// 返回entity中带有标签选项opt的字段，不受Filter影响
+// 这里只检查类型，因此属性可以是int64、*time.Time等decodeEntity不支持的类型，返回的Field没有val
+func optionField(e interface{}, opt string) (Field, bool) {
+	v := reflect.Indirect(reflect.ValueOf(e))
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if name, opts := parseTag(field); opts.has(opt) {
+			return Field{
+				Name:           field.Name,
+				TableFieldName: name,
+				originVal:      v.Field(i).Interface(),
+				opts:           opts,
+			}, true
+		}
+	}
+	return Field{}, false
+}
+
+// 返回entity的软删除字段名，没有软删除字段时返回""
+// 优先使用SoftDeleter接口，否则查找带有softDelete选项的属性，见optionField
+func softDeleteColumn(e interface{}) string {
+	if deleter, ok := e.(SoftDeleter); ok {
+		return deleter.SoftDeleteColumn()
+	}
+	field, _ := optionField(e, tagSoftDelete)
+	return field.TableFieldName
+}
+
 // 为o的name属性设置val值
 func setValue(o interface{}, name string, val interface{}) {
+	elem := reflect.ValueOf(o).Elem()
+	setFieldValue(elem, fieldIndexes(elem.Type()), name, val)
+}
+
+// 为结构体elem的name属性设置val值，indexes为该结构体的属性下标映射
+func setFieldValue(elem reflect.Value, indexes map[string]int, name string, val interface{}) {
 
 	var s string
 	switch val.(type) {
@@ -89,7 +203,11 @@ func setValue(o interface{}, name string, val interface{}) {
 		s = fmt.Sprintf("%s", val)
 	}
 
-	field := reflect.ValueOf(o).Elem().FieldByName(name)
+	idx, ok := indexes[name]
+	if !ok {
+		return
+	}
+	field := elem.Field(idx)
 	var (
 		tv  interface{}
 		err error
@@ -111,20 +229,31 @@ func setValue(o interface{}, name string, val interface{}) {
 	field.Set(reflect.ValueOf(tv))
 }
 
+// 将值转换为可以直接拼接进SQL的字面量，值会通过当前方言转义
+// 这是non-prepare模式下所有值的生成方式，见Dialect.Literal
+func literalValue(v interface{}) string {
+	return dialect.Literal(v)
+}
+
 func intToString(v int) string {
 	return strconv.Itoa(v)
 }
 
 func dateToString(v time.Time) string {
-	return stringValue(v.Format(datetimeFormat))
+	return literalValue(v)
 }
 
 func stringValue(s string) string {
-	return "'" + s + "'"
+	return literalValue(s)
 }
 
+// 返回通过方言引用后的名称，"table.column"形式的名称会分别引用每一部分
 func stringName(s string) string {
-	return "`" + s + "`"
+	parts := strings.Split(s, ".")
+	for i, part := range parts {
+		parts[i] = dialect.Quote(part)
+	}
+	return strings.Join(parts, ".")
 }
 
 func contains(s1 string, s2 string, ss []string) bool {
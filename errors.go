@@ -0,0 +1,90 @@
+package sqlmaker
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 可移植的错误类型，执行SQL时数据库驱动返回的错误会被转换为对应的DBError
+// 调用者可以通过errors.Is判断错误类型，而不需要关心具体的MySQL错误码
+var (
+	// 调用ExecQueryOne时，如果没有查询到任何数据，会返回这个错误
+	ErrNotFound = errors.New("record not found")
+
+	// 带有乐观锁版本号的更新语句没有更新任何数据，说明数据已经被其它地方修改过了
+	ErrStaleObject = errors.New("stale object")
+
+	// 开启字段校验后，如果条件表达式中出现了entity中不存在的字段，Make会返回包装了这个错误的错误
+	ErrUnknownColumn = errors.New("unknown column")
+
+	// 更新或删除语句没有任何WHERE条件，会修改整张表的数据，除非调用了AllowFullTable，否则Make会返回这个错误
+	ErrUnsafeStatement = errors.New("unsafe statement: update or delete without where")
+
+	// 违反唯一约束，例如主键或唯一索引重复
+	ErrDuplicateKey = errors.New("duplicate key")
+
+	// 违反外键约束
+	ErrForeignKey = errors.New("foreign key violation")
+
+	// 事务发生死锁，被数据库回滚
+	ErrDeadlock = errors.New("deadlock")
+
+	// 等待行锁超时
+	ErrLockWaitTimeout = errors.New("lock wait timeout")
+)
+
+// MySQL错误码到可移植错误的映射
+var mysqlErrors = map[uint16]error{
+	1022: ErrDuplicateKey, // ER_DUP_KEY
+	1062: ErrDuplicateKey, // ER_DUP_ENTRY
+	1586: ErrDuplicateKey, // ER_DUP_ENTRY_WITH_KEY_NAME
+	1216: ErrForeignKey,   // ER_NO_REFERENCED_ROW
+	1217: ErrForeignKey,   // ER_ROW_IS_REFERENCED
+	1451: ErrForeignKey,   // ER_ROW_IS_REFERENCED_2
+	1452: ErrForeignKey,   // ER_NO_REFERENCED_ROW_2
+	1213: ErrDeadlock,     // ER_LOCK_DEADLOCK
+	1205: ErrLockWaitTimeout,
+}
+
+// 数据库错误，包装了驱动返回的原始错误
+// errors.Is(err, ErrDeadlock)等判断会通过Kind进行，而errors.As可以取出原始的*mysql.MySQLError
+type DBError struct {
+	// 可移植的错误类型，例如ErrDuplicateKey
+	Kind error
+
+	// MySQL错误码
+	Number uint16
+
+	// 驱动返回的原始错误
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DBError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// 将驱动返回的错误转换为DBError，无法识别的错误会原样返回
+func translateError(err error) error {
+	var myErr *mysql.MySQLError
+	if err == nil || !errors.As(err, &myErr) {
+		return err
+	}
+	kind, ok := mysqlErrors[myErr.Number]
+	if !ok {
+		return err
+	}
+	return &DBError{
+		Kind:   kind,
+		Number: myErr.Number,
+		Err:    err,
+	}
+}
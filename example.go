@@ -0,0 +1,66 @@
+package sqlmaker
+
+import "reflect"
+
+// CondFromExample的选项
+type ExampleOption func(*exampleOptions)
+
+type exampleOptions struct {
+	// 零值也作为条件的字段，allZero为true时所有字段都会作为条件
+	zeroFields map[string]bool
+	allZero    bool
+
+	// 字符串字段是否使用LIKE模糊匹配
+	like bool
+}
+
+// 零值默认会被忽略，该选项使指定字段的零值也作为条件
+// names可以是结构体属性名或数据表字段名，不传入时所有字段的零值都会作为条件
+func IncludeZero(names ...string) ExampleOption {
+	return func(opts *exampleOptions) {
+		if len(names) == 0 {
+			opts.allZero = true
+		}
+		for _, name := range names {
+			opts.zeroFields[name] = true
+		}
+	}
+}
+
+// 字符串字段使用LIKE进行模糊匹配(同Cond.Contains，值中的通配符会被转义)，而不是相等
+func LikeStrings() ExampleOption {
+	return func(opts *exampleOptions) {
+		opts.like = true
+	}
+}
+
+// 根据一个部分赋值的entity生成条件表达式(Query-by-example)
+// entity的每个非零值字段都会生成一个相等条件，并用AND连接，例如：
+// CondFromExample(User{Name: "Mike", Status: 2}) 生成 `name`=? AND `status`=?
+// 字段名通过"field"标签获取，和生成其它SQL语句时一致。e可以是结构体或结构体指针
+func CondFromExample(e interface{}, opts ...ExampleOption) *Cond {
+
+	options := &exampleOptions{zeroFields: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	v := reflect.ValueOf(e)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	cond := NewPrepareCond()
+	for _, field := range decodeEntity(v.Interface(), nil) {
+		if reflect.ValueOf(field.originVal).IsZero() && !options.allZero &&
+			!options.zeroFields[field.Name] && !options.zeroFields[field.TableFieldName] {
+			continue
+		}
+		if s, ok := field.originVal.(string); ok && options.like {
+			cond.Contains(field.TableFieldName, s)
+		} else {
+			cond.Eq(field.TableFieldName, field.originVal)
+		}
+	}
+	return cond
+}
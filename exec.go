@@ -1,13 +1,20 @@
 package sqlmaker
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"io"
-	"log"
+	"reflect"
+	"time"
 )
 
-var DBNotSetError = errors.New("db is not set")
+var (
+	DBNotSetError = errors.New("db is not set")
+
+	// 调用ExecQueryAll时，如果传入的参数不是指向结构体切片的指针，会返回这个错误
+	DestNotSliceError = errors.New("dest must be a pointer to a slice of struct")
+)
 
 // 查询的返回结果
 type QueryResult struct {
@@ -53,45 +60,116 @@ func (maker *SqlMaker) checkDB() bool {
 }
 
 // 执行SQL语句，返回执行影响的数据行数
+// 如果entity通过Track被追踪并且没有任何字段被修改，不会执行SQL，直接返回0；执行成功后会刷新快照
+// 如果entity有乐观锁版本号字段，没有更新任何数据时返回ErrStaleObject，更新成功后entity的版本号会加1
 func (maker *SqlMaker) Exec() (int64, error) {
-
-	if !maker.checkDB() {
-		return 0, DBNotSetError
-	}
-
-	_sql := maker.BuildMake()
-	var (
-		result sql.Result
-		err    error
-	)
-	wLog("### Exec SQL: %s", _sql)
-
-	// prepare和non-prepare逻辑不同
-	if maker.IsPrepare() {
-		_, result, err = maker.execPrepare(_sql, false)
-	} else {
-		result, err = maker.db.Exec(_sql)
+	if maker.Build().unchanged {
+		return 0, nil
 	}
-
+	result, err := maker.run(maker.operation(), false)
 	if err != nil {
 		return 0, err
 	}
+	if maker.version != nil {
+		if result.RowsAffected == 0 {
+			return 0, ErrStaleObject
+		}
+		increaseVersion(maker.maker.entity, maker.version.Name)
+	}
+	if _, ok := trackedSnapshot(maker.maker.entity); ok {
+		Track(maker.maker.entity)
+	}
+	return result.RowsAffected, nil
+}
 
-	return result.RowsAffected()
+// 更新成功之后，将entity的版本号加1，只有entity是指针时才能修改
+func increaseVersion(e Entity, name string) {
+	v := reflect.ValueOf(e)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+	field := v.Elem().FieldByName(name)
+	if !field.CanSet() {
+		return
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(field.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(field.Uint() + 1)
+	}
 }
 
 // 执行查询多个数据SQL，返回的QueryResult对象可以迭代，通过迭代QueryResult
 // 来将查询结果转换为具体的entity。
 func (maker *SqlMaker) ExecQueryMany() (*QueryResult, error) {
-	return maker.execQuery(true, false, nil, nil)
+	result, err := maker.run(OpSelect, false)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryResult{
+		names:       maker.Names(),
+		valuesTable: result.Rows,
+	}, nil
+}
+
+// 执行查询多个数据SQL，并将所有结果直接解码到dest中，免去迭代QueryResult的麻烦
+// dest必须是指向切片的指针，切片元素可以是结构体或结构体指针，例如*[]User或*[]*User
+// 查询到的每一行都会新建一个元素追加到切片末尾
+func (maker *SqlMaker) ExecQueryAll(dest interface{}) error {
+
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.Elem().Kind() != reflect.Slice {
+		return DestNotSliceError
+	}
+
+	slice := destVal.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return DestNotSliceError
+	}
+
+	result, err := maker.ExecQueryMany()
+	if err != nil {
+		return err
+	}
+
+	indexes := fieldIndexes(elemType)
+	for _, values := range result.valuesTable {
+		elem := reflect.New(elemType)
+		for i, name := range result.names {
+			setFieldValue(elem.Elem(), indexes, name, values[i])
+		}
+		if isPtr {
+			slice = reflect.Append(slice, elem)
+		} else {
+			slice = reflect.Append(slice, elem.Elem())
+		}
+	}
+	result.valuesTable = nil
+
+	destVal.Elem().Set(slice)
+	return nil
 }
 
 // 执行查询单个数据，确认SQL只会返回一个数据时调用该函数
 // 单个数据通过传入指针的方式赋值，确保o是一个指针
+// 如果没有查询到数据，返回ErrNotFound，此时o不会被修改
 func (maker *SqlMaker) ExecQueryOne(o interface{}) error {
 
-	_, err := maker.execQuery(false, false, o, nil)
-	return err
+	result, err := maker.run(OpSelect, true)
+	if err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return ErrNotFound
+	}
+	setValues(o, maker.Names(), result.Rows[0])
+	return nil
 }
 
 // 执行统计数据，如果SQL是统计的数据，返回的结果是一个整数，则可以调用该函数
@@ -101,52 +179,109 @@ func (maker *SqlMaker) ExecCount() (int, error) {
 		maker.Count()
 	}
 
-	var cnt int
-	_, err := maker.execQuery(false, true, nil, &cnt)
-	return cnt, err
+	result, err := maker.run(OpCount, false)
+	if err != nil {
+		return 0, err
+	}
+	return result.Count, nil
 }
 
-// 通过search函数，囊括了上述三种查询
-func (maker *SqlMaker) execQuery(many, count bool, o interface{}, i *int) (*QueryResult, error) {
+// Hook链的最后一环，真正执行Statement
+func (maker *SqlMaker) execute(ctx context.Context, stmt *Statement) (*Result, error) {
 
 	if !maker.checkDB() {
 		return nil, DBNotSetError
 	}
 
-	_sql := maker.BuildMake()
+	ctx, span := startSpan(ctx, stmt)
+	start := time.Now()
+	var (
+		result *Result
+		rows   int64
+		err    error
+	)
+	switch stmt.Op {
+	case OpSelect, OpCount:
+		result, err = maker.query(ctx, stmt)
+		if err == nil {
+			rows = int64(len(result.Rows))
+			if stmt.Op == OpCount {
+				rows = 1
+			}
+		}
+	default:
+		result, err = maker.exec(ctx, stmt)
+		if err == nil {
+			rows = result.RowsAffected
+		}
+	}
+	err = translateError(err)
+
+	endSpan(span, rows, err)
+	observe(stmt, time.Since(start), err)
+	maker.logExec(string(stmt.Op), stmt, start, rows, err)
+	return result, err
+}
+
+// 执行非查询SQL
+func (maker *SqlMaker) exec(ctx context.Context, stmt *Statement) (*Result, error) {
+
+	var (
+		result sql.Result
+		err    error
+	)
+
+	// prepare和non-prepare逻辑不同
+	if len(stmt.Args) > 0 {
+		_, result, err = maker.execPrepare(ctx, stmt, false)
+	} else {
+		result, err = maker.db.ExecContext(ctx, stmt.SQL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: affected}, nil
+}
+
+// 执行查询SQL并解析返回的数据
+func (maker *SqlMaker) query(ctx context.Context, stmt *Statement) (*Result, error) {
+
 	var (
 		rows *sql.Rows
 		err  error
 	)
 
-	wLog("### Exec query sql: %s", _sql)
-
-	if maker.IsPrepare() {
-		rows, _, err = maker.execPrepare(_sql, true)
+	if len(stmt.Args) > 0 {
+		rows, _, err = maker.execPrepare(ctx, stmt, true)
 	} else {
-		rows, err = maker.db.Query(_sql)
+		rows, err = maker.db.QueryContext(ctx, stmt.SQL)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	defer safeClose(rows)
 
-	names := maker.Names()
-	valuesTable := make([][]interface{}, 0)
+	result := &Result{Rows: make([][]interface{}, 0)}
 
 	for rows.Next() {
 
 		// 统计，直接将结果赋值为int后返回
-		if count {
-			err = rows.Scan(i)
-			return nil, err
+		if stmt.Op == OpCount {
+			err = rows.Scan(&result.Count)
+			return result, err
 		}
 
 		// 保存当前row返回的值
-		values := make([]interface{}, len(names))
+		values := make([]interface{}, len(stmt.Names))
 
 		// 指向values所有元素的指针，用于给values赋值
-		valuePts := make([]interface{}, len(names))
+		valuePts := make([]interface{}, len(stmt.Names))
 		for i := range valuePts {
 			valuePts[i] = &values[i]
 		}
@@ -156,40 +291,49 @@ func (maker *SqlMaker) execQuery(many, count bool, o interface{}, i *int) (*Quer
 		if err != nil {
 			return nil, err
 		}
-		if !many {
+		result.Rows = append(result.Rows, values)
+
+		if stmt.single {
 			// 只用解析第一个数据即可返回
-			setValues(o, names, values)
-			return nil, nil
+			return result, nil
 		}
-
-		valuesTable = append(valuesTable, values)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return &QueryResult{
-		names:       names,
-		valuesTable: valuesTable,
-	}, nil
+	return result, nil
 }
 
-// 指向PrepareSQL语句，这会创建一个SQL stmt，随后调用maker的Values()函数获取具体的
-// 值传给stmt执行，详情见SqlMaker.Values文档
-func (maker *SqlMaker) execPrepare(_sql string, isQuery bool) (*sql.Rows, sql.Result, error) {
-	stmt, err := maker.db.Prepare(_sql)
-	if err != nil {
-		return nil, nil, err
+// 指向PrepareSQL语句，这会创建一个SQL stmt，随后将Statement的Args传给stmt执行
+// stmt会优先从db对应的prepare语句缓存中获取，见SetStmtCacheSize
+func (maker *SqlMaker) execPrepare(ctx context.Context, statement *Statement, isQuery bool) (*sql.Rows, sql.Result, error) {
+	var stmt *sql.Stmt
+	if cache := getStmtCache(maker.db); cache != nil {
+		cs, err := cache.acquire(ctx, statement.SQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 返回的rows会保持stmt可用，直到rows被关闭，因此这里可以直接归还
+		defer cache.release(cs)
+		stmt = cs.stmt
+	} else {
+		var err error
+		stmt, err = maker.db.PrepareContext(ctx, statement.SQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer safeClose(stmt)
 	}
-	defer safeClose(stmt)
-
-	wLog("### prepare values: %s", printValues(maker.Values()))
 
 	if isQuery {
-		rows, err := stmt.Query(maker.Values()...)
+		rows, err := stmt.QueryContext(ctx, statement.Args...)
 		if err != nil {
 			return nil, nil, err
 		}
 		return rows, nil, nil
 	} else {
-		result, err := stmt.Exec(maker.Values()...)
+		result, err := stmt.ExecContext(ctx, statement.Args...)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -201,12 +345,46 @@ func (maker *SqlMaker) execPrepare(_sql string, isQuery bool) (*sql.Rows, sql.Re
 func safeClose(closer io.Closer) {
 	err := closer.Close()
 	if err != nil {
-		log.Printf("error close: %s", err)
+		wLog(LevelError, "close failed", LogFields{Err: err})
+	}
+}
+
+// 输出一条SQL执行日志，出错时使用LevelError级别，否则使用LevelDebug级别
+// 如果执行耗时超过了慢查询阈值，则改为输出一条LevelWarn级别的慢查询日志
+func (maker *SqlMaker) logExec(msg string, stmt *Statement, start time.Time, rows int64, err error) {
+	fields := LogFields{
+		SQL:          stmt.SQL,
+		Args:         stmt.Args,
+		Duration:     time.Since(start),
+		RowsAffected: rows,
+		Err:          err,
+	}
+
+	threshold := maker.threshold()
+	switch {
+	case err != nil:
+		maker.log(LevelError, msg, fields)
+	case threshold > 0 && fields.Duration >= threshold:
+		fields.SQL = interpolate(stmt.SQL, stmt.Args)
+		fields.Caller = stmt.caller
+		maker.log(LevelWarn, "slow "+msg, fields)
+	default:
+		maker.log(LevelDebug, msg, fields)
+	}
+}
+
+// 返回Maker实际使用的慢查询阈值，Maker没有单独设置时使用全局设置
+func (maker *SqlMaker) threshold() time.Duration {
+	if maker.slowThreshold > 0 {
+		return maker.slowThreshold
 	}
+	return slowThreshold
 }
 
 func setValues(o interface{}, names []string, values []interface{}) {
+	elem := reflect.ValueOf(o).Elem()
+	indexes := fieldIndexes(elem.Type())
 	for i, name := range names {
-		setValue(o, name, values[i])
+		setFieldValue(elem, indexes, name, values[i])
 	}
 }
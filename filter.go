@@ -0,0 +1,344 @@
+package sqlmaker
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 查询参数中的运算符，参数名格式为"字段名__运算符"，省略运算符时为FilterEq
+const (
+	FilterEq         = "eq"
+	FilterNotEq      = "ne"
+	FilterGt         = "gt"
+	FilterGte        = "gte"
+	FilterLt         = "lt"
+	FilterLte        = "lte"
+	FilterLike       = "like"
+	FilterStartsWith = "startswith"
+	FilterEndsWith   = "endswith"
+	FilterIn         = "in"
+	FilterNotIn      = "notin"
+	FilterBetween    = "between"
+	FilterIsNull     = "isnull"
+)
+
+// 保留的查询参数名，分别用于排序和分页
+const (
+	FilterSortParam     = "sort"
+	FilterPageParam     = "page"
+	FilterPageSizeParam = "page_size"
+)
+
+// 查询参数的白名单，规定了哪些字段可以筛选、每个字段允许哪些运算符，以及哪些字段可以排序
+type FilterSpec struct {
+	columns  map[string]reflect.Type
+	allowed  map[string]map[string]bool
+	sortable map[string]bool
+
+	// 每页数量的默认值和最大值
+	defaultPageSize int
+	maxPageSize     int
+
+	// 页码的最大值，避免计算LIMIT偏移量时溢出
+	maxPage int
+}
+
+// 根据entity新建一个查询参数白名单，新建之后没有任何字段可以筛选和排序，
+// 需要通过Allow和Sortable逐个开放
+func NewFilterSpec(e Entity) *FilterSpec {
+	t := reflect.TypeOf(e)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	columns := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _ := parseTag(field)
+		columns[name] = field.Type
+	}
+	return &FilterSpec{
+		columns:         columns,
+		allowed:         make(map[string]map[string]bool),
+		sortable:        make(map[string]bool),
+		defaultPageSize: 20,
+		maxPageSize:     100,
+		maxPage:         10000,
+	}
+}
+
+// 允许通过ops运算符筛选column字段，不传入ops时只允许FilterEq
+// column必须是entity的字段，否则会panic
+func (spec *FilterSpec) Allow(column string, ops ...string) *FilterSpec {
+	spec.mustColumn(column)
+	if len(ops) == 0 {
+		ops = []string{FilterEq}
+	}
+	if spec.allowed[column] == nil {
+		spec.allowed[column] = make(map[string]bool)
+	}
+	for _, op := range ops {
+		spec.allowed[column][op] = true
+	}
+	return spec
+}
+
+// 允许按照columns字段排序，columns必须是entity的字段，否则会panic
+func (spec *FilterSpec) Sortable(columns ...string) *FilterSpec {
+	for _, column := range columns {
+		spec.mustColumn(column)
+		spec.sortable[column] = true
+	}
+	return spec
+}
+
+// 设置每页数量的默认值和最大值，默认分别为20和100
+func (spec *FilterSpec) PageSize(defaultSize, maxSize int) *FilterSpec {
+	spec.defaultPageSize = defaultSize
+	spec.maxPageSize = maxSize
+	return spec
+}
+
+// 设置页码的最大值，默认为10000，超过最大值的页码会被当作校验错误
+func (spec *FilterSpec) MaxPage(max int) *FilterSpec {
+	spec.maxPage = max
+	return spec
+}
+
+func (spec *FilterSpec) mustColumn(column string) {
+	if _, ok := spec.columns[column]; !ok {
+		panic(fmt.Sprintf("%s is not a column of the entity", column))
+	}
+}
+
+// 从查询参数中解析出的查询条件、排序和分页参数
+type Filter struct {
+	Cond     *Cond
+	OrderBy  []string
+	Page     int
+	PageSize int
+}
+
+// 将条件、排序和分页应用到maker上
+func (filter *Filter) Apply(maker *SqlMaker) *SqlMaker {
+	return maker.Cond(filter.Cond).OrderBy(filter.OrderBy...).Page(filter.Page, filter.PageSize)
+}
+
+// 某个查询参数的校验错误
+type FilterError struct {
+	Param  string
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter parameter %q: %s", e.Param, e.Reason)
+}
+
+// 所有查询参数的校验错误，按照参数名排序
+type FilterErrors []*FilterError
+
+func (errs FilterErrors) Error() string {
+	s := make([]string, 0, len(errs))
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// 解析形如"status=2&age__gte=18&name__like=mi&sort=-create_date"的查询字符串，见ParseFilter
+func ParseFilterString(query string, spec *FilterSpec) (*Filter, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, FilterErrors{{Param: query, Reason: err.Error()}}
+	}
+	return ParseFilter(values, spec)
+}
+
+// 将HTTP查询参数解析为查询条件、排序和分页参数
+// 筛选参数的格式为"字段名__运算符=值"，例如"age__gte=18"，多个筛选参数之间用AND连接
+// in、notin和between的值用逗号分隔，isnull的值为true或false
+// 排序参数为"sort=-create_date,id"，"-"表示降序；分页参数为"page"和"page_size"
+// 每个参数只能出现一次，所有参数都必须在spec的白名单中，值会按照字段的类型进行转换，校验失败时返回FilterErrors
+func ParseFilter(values url.Values, spec *FilterSpec) (*Filter, error) {
+
+	filter := &Filter{
+		Cond:     NewPrepareCond(),
+		Page:     1,
+		PageSize: spec.defaultPageSize,
+	}
+	errs := make(FilterErrors, 0)
+
+	params := make([]string, 0, len(values))
+	for param := range values {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		if len(values[param]) > 1 {
+			errs = append(errs, &FilterError{Param: param, Reason: "must not be repeated"})
+			continue
+		}
+		value := values.Get(param)
+
+		var err string
+		switch param {
+		case FilterSortParam:
+			filter.OrderBy, err = spec.parseSort(value)
+		case FilterPageParam:
+			filter.Page, err = parsePositive(value)
+			if err == "" && filter.Page > spec.maxPage {
+				err = fmt.Sprintf("must not be greater than %d", spec.maxPage)
+			}
+		case FilterPageSizeParam:
+			filter.PageSize, err = parsePositive(value)
+			if err == "" && filter.PageSize > spec.maxPageSize {
+				err = fmt.Sprintf("must not be greater than %d", spec.maxPageSize)
+			}
+		default:
+			err = spec.parseCond(filter.Cond, param, value)
+		}
+
+		if err != "" {
+			errs = append(errs, &FilterError{Param: param, Reason: err})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return filter, nil
+}
+
+// 解析排序参数
+func (spec *FilterSpec) parseSort(value string) ([]string, string) {
+	orders := make([]string, 0)
+	for _, order := range strings.Split(value, ",") {
+		column := strings.TrimPrefix(order, "-")
+		if !spec.sortable[column] {
+			return nil, fmt.Sprintf("sorting by %q is not allowed", column)
+		}
+		orders = append(orders, order)
+	}
+	return orders, ""
+}
+
+// 解析一个筛选参数，并将对应的条件加入cond
+func (spec *FilterSpec) parseCond(cond *Cond, param, value string) string {
+
+	column, op := param, FilterEq
+	if i := strings.LastIndex(param, "__"); i >= 0 {
+		column, op = param[:i], param[i+2:]
+	}
+
+	ops, ok := spec.allowed[column]
+	if !ok {
+		return fmt.Sprintf("filtering by %q is not allowed", column)
+	}
+	if !ops[op] {
+		return fmt.Sprintf("operator %q is not allowed for %q", op, column)
+	}
+	t := spec.columns[column]
+
+	switch op {
+	case FilterIn, FilterNotIn, FilterBetween:
+		vs := make([]interface{}, 0)
+		for _, s := range strings.Split(value, ",") {
+			v, err := convertFilterValue(t, s)
+			if err != "" {
+				return err
+			}
+			vs = append(vs, v)
+		}
+		switch {
+		case op == FilterIn:
+			cond.In(column, vs)
+		case op == FilterNotIn:
+			cond.NotIn(column, vs)
+		case len(vs) != 2:
+			return "between requires exactly two values"
+		default:
+			cond.Between(column, vs[0], vs[1])
+		}
+		return ""
+	case FilterIsNull:
+		isNull, err := strconv.ParseBool(value)
+		if err != nil {
+			return "must be true or false"
+		}
+		if isNull {
+			cond.IsNull(column)
+		} else {
+			cond.IsNotNull(column)
+		}
+		return ""
+	case FilterLike:
+		cond.Contains(column, value)
+		return ""
+	case FilterStartsWith:
+		cond.StartsWith(column, value)
+		return ""
+	case FilterEndsWith:
+		cond.EndsWith(column, value)
+		return ""
+	}
+
+	v, err := convertFilterValue(t, value)
+	if err != "" {
+		return err
+	}
+	// 注意Cond中Lt表示大于，St表示小于
+	switch op {
+	case FilterEq:
+		cond.Eq(column, v)
+	case FilterNotEq:
+		cond.NotEq(column, v)
+	case FilterGt:
+		cond.Lt(column, v)
+	case FilterGte:
+		cond.LtEq(column, v)
+	case FilterLt:
+		cond.St(column, v)
+	case FilterLte:
+		cond.StEq(column, v)
+	default:
+		return fmt.Sprintf("unknown operator %q", op)
+	}
+	return ""
+}
+
+// 按照字段类型转换参数值，支持int、string和time.Time(格式为"2006-01-02 15:04:05"或"2006-01-02")
+func convertFilterValue(t reflect.Type, s string) (interface{}, string) {
+	if t == nil {
+		return s, ""
+	}
+	switch t.Name() {
+	case "int":
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Sprintf("%q is not an integer", s)
+		}
+		return v, ""
+	case "Time":
+		for _, layout := range []string{datetimeFormat, "2006-01-02"} {
+			if v, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return v, ""
+			}
+		}
+		return nil, fmt.Sprintf("%q is not a valid time", s)
+	default:
+		return s, ""
+	}
+}
+
+func parsePositive(s string) (int, string) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v <= 0 {
+		return 0, "must be a positive integer"
+	}
+	return v, ""
+}
@@ -0,0 +1,68 @@
+//go:build go1.18
+// +build go1.18
+
+package sqlmaker
+
+import (
+	"strings"
+	"testing"
+)
+
+// 解析MySQL字符串字面量，返回原始字符串
+func unquoteMySQL(t *testing.T, s string) string {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		t.Fatalf("not a string literal: %q", s)
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			t.Fatalf("unescaped quote in literal: %q", s)
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			t.Fatalf("dangling backslash in literal: %q", s)
+		}
+		switch s[i] {
+		case '0':
+			b.WriteByte(0)
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'Z':
+			b.WriteByte('\x1a')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func FuzzLiteralRoundTrip(f *testing.F) {
+
+	for _, seed := range []string{"", "Mike", "O'Brien", `a\'b`, "\x00\n\r\x1a\"", "' OR 1=1 --", "中文\\"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+
+		// Cond.getVal
+		where := NewCond().Eq("name", s).Make()
+		if got := unquoteMySQL(t, strings.TrimPrefix(where, "`name`=")); got != s {
+			t.Errorf("cond: expect %q, got %q", s, got)
+		}
+
+		// decodeEntity
+		maker := NewInsertMaker(User{Phone: s}).Filter("phone").Prepare(false)
+		values := strings.TrimPrefix(maker.BuildMake(), "INSERT INTO user(`phone`) VALUES(")
+		if got := unquoteMySQL(t, strings.TrimSuffix(values, ")")); got != s {
+			t.Errorf("entity: expect %q, got %q", s, got)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package sqlmaker
+
+import (
+	"context"
+	"sync"
+)
+
+// SQL语句的操作类型
+type Operation string
+
+const (
+	OpInsert  Operation = "insert"
+	OpReplace Operation = "replace"
+	OpUpdate  Operation = "update"
+	OpDelete  Operation = "delete"
+	OpSelect  Operation = "select"
+	OpCount   Operation = "count"
+)
+
+// 一次SQL执行的语句，在Hook链中传递
+// Hook可以在调用next之前修改SQL和Args，从而改写将要执行的语句
+type Statement struct {
+	// 操作类型
+	Op Operation
+
+	// 操作的表名
+	Table string
+
+	// 将要执行的SQL
+	SQL string
+
+	// prepare语句对应的值，为空时SQL会直接执行而不经过prepare
+	Args []interface{}
+
+	// 查询语句返回的每一列对应的结构体属性名，只有OpSelect会设置
+	Names []string
+
+	// 是否只需要查询结果的第一行，ExecQueryOne会设置
+	single bool
+
+	// 调用sqlmaker执行SQL的代码位置，用于慢查询日志。需要在进入Hook链之前获取，
+	// 否则定义在其它包中的Hook会被当作调用者。只有设置了慢查询阈值时才会获取
+	caller string
+}
+
+// 一次SQL执行的结果
+type Result struct {
+	// Exec影响的数据行数
+	RowsAffected int64
+
+	// ExecCount统计到的数量
+	Count int
+
+	// 查询返回的每一行数据，每一行的值和Statement.Names一一对应
+	Rows [][]interface{}
+}
+
+// 执行Statement的函数，Hook通过调用next将Statement交给下一个Hook，最后一个next会真正执行SQL
+type Handler func(ctx context.Context, stmt *Statement) (*Result, error)
+
+// SQL执行的拦截器。Hook可以观察和改写Statement，观察执行结果，
+// 也可以不调用next直接返回结果，从而跳过真正的执行，此时返回nil结果等同于返回空的Result
+type Hook func(ctx context.Context, stmt *Statement, next Handler) (*Result, error)
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hook
+)
+
+// 添加全局Hook，所有Maker的Exec、ExecQueryMany、ExecQueryOne和ExecCount都会经过这些Hook
+// 先添加的Hook在外层，会先看到Statement，后看到执行结果
+func Use(hs ...Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, hs...)
+}
+
+// 为Maker添加Hook，Maker的Hook在全局Hook的内层执行
+func (maker *SqlMaker) Use(hs ...Hook) *SqlMaker {
+	maker.hooks = append(maker.hooks, hs...)
+	return maker
+}
+
+// 为Maker设置执行SQL时使用的context，不设置时使用context.Background()
+func (maker *SqlMaker) SetContext(ctx context.Context) *SqlMaker {
+	maker.ctx = ctx
+	return maker
+}
+
+// 生成Statement并通过Hook链执行
+func (maker *SqlMaker) run(op Operation, single bool) (*Result, error) {
+
+	_sql, err := maker.Build().Make()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{
+		Op:     op,
+		Table:  maker.maker.tableName,
+		SQL:    _sql,
+		single: single,
+	}
+	if maker.IsPrepare() {
+		stmt.Args = maker.Values()
+	}
+	if op == OpSelect {
+		stmt.Names = maker.Names()
+	}
+	if maker.threshold() > 0 {
+		stmt.caller = callerLocation()
+	}
+
+	hooksMu.RLock()
+	chain := make([]Hook, 0, len(hooks)+len(maker.hooks))
+	chain = append(chain, hooks...)
+	hooksMu.RUnlock()
+	chain = append(chain, maker.hooks...)
+
+	handler := Handler(maker.execute)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hook, next := chain[i], handler
+		handler = func(ctx context.Context, stmt *Statement) (*Result, error) {
+			return hook(ctx, stmt, next)
+		}
+	}
+
+	ctx := maker.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	result, err := handler(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	// Hook不调用next直接返回nil时，视为没有任何结果
+	if result == nil {
+		result = &Result{}
+	}
+	return result, nil
+}
+
+// 返回Maker对应的操作类型
+func (maker *SqlMaker) operation() Operation {
+	for _, stat := range maker.statOrder {
+		switch stat {
+		case "insert":
+			return OpInsert
+		case "replace":
+			return OpReplace
+		case "update":
+			return OpUpdate
+		case "delete":
+			return OpDelete
+		case "select":
+			if maker.isCount {
+				return OpCount
+			}
+			return OpSelect
+		}
+	}
+	return OpSelect
+}
@@ -0,0 +1,55 @@
+package sqlmaker
+
+import "strings"
+
+// 返回替换了所有prepare值的SQL语句，可以直接复制到MySQL客户端中执行，便于调试和重现问题
+// 值会通过当前方言转义，字符串、时间、[]byte和NULL都会被转换为正确的字面量
+// 注意：该函数只用于调试和日志，执行SQL时请始终使用prepare语句和Values()，
+// 不要将该函数的返回值交给数据库执行
+// 和Make()一样，SQL无法生成时(例如ErrUnsafeStatement、ErrUnknownColumn)返回对应的错误
+func (maker *SqlMaker) Interpolate() (string, error) {
+	_sql, err := maker.Build().Make()
+	if err != nil {
+		return "", err
+	}
+	return interpolate(_sql, maker.Values()), nil
+}
+
+// 将args依次替换进_sql的"?"占位符，返回可以直接执行的SQL
+// 字符串字面量和反引号包裹的名称中的"?"不会被替换
+// 多余的占位符会原样保留，多余的参数会被忽略
+func interpolate(_sql string, args []interface{}) string {
+
+	if len(args) == 0 {
+		return _sql
+	}
+
+	var (
+		b     strings.Builder
+		quote byte
+		next  int
+	)
+	for i := 0; i < len(_sql); i++ {
+		c := _sql[i]
+		switch {
+		case quote != 0:
+			// 在字面量或名称中，遇到反斜杠转义时直接跳过下一个字符
+			if c == '\\' && quote != '`' && i+1 < len(_sql) {
+				b.WriteByte(c)
+				i++
+				c = _sql[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && next < len(args):
+			b.WriteString(dialect.Literal(args[next]))
+			next++
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
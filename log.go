@@ -4,33 +4,229 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var (
-	logger *log.Logger
-	debug  = false
+// sqlmaker的包路径，用于在调用栈中区分sqlmaker内部的函数
+var packagePath = reflect.TypeOf(SqlMaker{}).PkgPath()
+
+// 日志级别，只有不低于当前级别的日志才会被输出
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota + 1
+	LevelInfo
+	LevelWarn
+	LevelError
+
+	// 关闭所有日志
+	LevelOff
 )
 
-func init() {
-	logger = log.New(os.Stdout, colorString("[sqlmaker-DEBUG] "),
-		log.Ldate|log.Ltime)
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	case LevelOff:
+		return "OFF"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(level))
+	}
+}
+
+// 一条日志的结构化字段，没有用到的字段为零值
+type LogFields struct {
+	// 执行的SQL语句
+	SQL string
+
+	// prepare语句对应的值
+	Args []interface{}
+
+	// SQL执行耗时
+	Duration time.Duration
+
+	// 影响或返回的数据行数
+	RowsAffected int64
+
+	// 执行出错时的错误
+	Err error
+
+	// 调用sqlmaker的代码位置，格式为"file:line"，只有慢查询日志会设置
+	Caller string
+}
+
+// 日志接口，sqlmaker所有的日志都通过该接口输出
+// 可以通过SetLogger全局设置，也可以通过SqlMaker.SetLogger为某个Maker单独设置
+// 日志级别的过滤由sqlmaker完成，Logger只需要负责输出
+type Logger interface {
+	Log(level LogLevel, msg string, fields LogFields)
 }
 
+var (
+	// 默认输出到标准输出，带有颜色
+	stdoutLogger = NewStdLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime), true)
+
+	logger   Logger = stdoutLogger
+	logLevel        = LevelOff
+
+	// 慢查询阈值，为0表示不记录慢查询
+	slowThreshold time.Duration
+)
+
+// 开启调试模式，这会把全局日志级别设置为LevelDebug，执行的每一条SQL都会被输出
 func DebugMode() {
-	debug = true
+	SetLogLevel(LevelDebug)
+}
+
+// 设置全局日志级别，默认为LevelOff，即不输出任何日志
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// 设置全局Logger，传入nil会恢复为默认的标准输出Logger
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdoutLogger
+	}
+	logger = l
+}
+
+// 设置全局慢查询阈值，执行耗时不低于该阈值的SQL会以LevelWarn级别输出日志
+// 日志中包含替换了prepare值的SQL、prepare值、数据行数和调用位置。默认为0，即不记录慢查询
+// 慢查询日志同样受到日志级别的限制，日志级别为LevelOff时不会输出
+func SetSlowThreshold(threshold time.Duration) {
+	slowThreshold = threshold
+}
+
+// 关闭默认Logger的颜色输出，适用于输出到文件或者不支持ANSI颜色的终端
+func DisableColor() {
+	stdoutLogger.color = false
+}
+
+// 为Maker单独设置Logger，不设置时使用全局Logger
+func (maker *SqlMaker) SetLogger(l Logger) *SqlMaker {
+	maker.logger = l
+	return maker
+}
+
+// 为Maker单独设置日志级别，不设置时使用全局日志级别
+func (maker *SqlMaker) SetLogLevel(level LogLevel) *SqlMaker {
+	maker.logLevel = level
+	return maker
+}
+
+// 为Maker单独设置慢查询阈值，不设置时使用全局阈值，见SetSlowThreshold
+func (maker *SqlMaker) SetSlowThreshold(threshold time.Duration) *SqlMaker {
+	maker.slowThreshold = threshold
+	return maker
+}
+
+// 通过Maker的Logger输出日志
+func (maker *SqlMaker) log(level LogLevel, msg string, fields LogFields) {
+	l, minLevel := logger, logLevel
+	if maker.logger != nil {
+		l = maker.logger
+	}
+	if maker.logLevel != 0 {
+		minLevel = maker.logLevel
+	}
+	if level < minLevel {
+		return
+	}
+	l.Log(level, msg, fields)
+}
+
+// 通过全局Logger输出日志，用于和Maker无关的场景
+func wLog(level LogLevel, msg string, fields LogFields) {
+	if level < logLevel {
+		return
+	}
+	logger.Log(level, msg, fields)
+}
+
+// 标准库log的适配器
+type StdLogger struct {
+	logger *log.Logger
+	color  bool
+}
+
+// 使用标准库的*log.Logger创建一个Logger，color表示是否使用ANSI颜色输出级别前缀
+func NewStdLogger(l *log.Logger, color bool) *StdLogger {
+	return &StdLogger{
+		logger: l,
+		color:  color,
+	}
+}
+
+func (l *StdLogger) Log(level LogLevel, msg string, fields LogFields) {
+	prefix := "[sqlmaker-" + level.String() + "] "
+	if l.color {
+		prefix = colorString(prefix, levelColor(level))
+	}
+
+	s := []string{prefix + msg}
+	if fields.SQL != "" {
+		s = append(s, "sql="+fields.SQL)
+	}
+	if len(fields.Args) > 0 {
+		s = append(s, "args=["+printValues(fields.Args)+"]")
+	}
+	if fields.Duration > 0 {
+		s = append(s, "duration="+fields.Duration.String())
+	}
+	if fields.RowsAffected > 0 {
+		s = append(s, fmt.Sprintf("rows=%d", fields.RowsAffected))
+	}
+	if fields.Err != nil {
+		s = append(s, "error="+fields.Err.Error())
+	}
+	if fields.Caller != "" {
+		s = append(s, "caller="+fields.Caller)
+	}
+	l.logger.Print(strings.Join(s, " "))
+}
+
+// 返回调用sqlmaker的代码位置，即调用栈中第一个不属于sqlmaker的函数
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") ||
+			strings.HasSuffix(frame.File, "_test.go") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
 }
 
-func wLog(s string, v ...interface{}) {
-	if debug {
-		logger.Printf(s, v...)
+func levelColor(level LogLevel) int {
+	switch level {
+	case LevelWarn:
+		return 33
+	case LevelError:
+		return 31
+	default:
+		return 32
 	}
 }
 
 // 返回有颜色的字体
-func colorString(s string) string {
-	return fmt.Sprintf("\033[%d;1m%s\033[0m", 32, s)
+func colorString(s string, color int) string {
+	return fmt.Sprintf("\033[%d;1m%s\033[0m", color, s)
 }
 
 func printValues(vs []interface{}) string {
@@ -43,7 +239,7 @@ func printValues(vs []interface{}) string {
 		case time.Time:
 			valStr = v.(time.Time).Format(datetimeFormat)
 		default:
-			valStr = fmt.Sprintf("%s", v)
+			valStr = fmt.Sprintf("%v", v)
 
 		}
 		s = append(s, valStr)
@@ -0,0 +1,55 @@
+//go:build go1.21
+// +build go1.21
+
+package sqlmaker
+
+import (
+	"context"
+	"log/slog"
+)
+
+// log/slog的适配器，LogFields中的字段会作为slog属性输出
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// 使用*slog.Logger创建一个Logger
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: l}
+}
+
+func (l *SlogLogger) Log(level LogLevel, msg string, fields LogFields) {
+	attrs := make([]slog.Attr, 0, 6)
+	if fields.SQL != "" {
+		attrs = append(attrs, slog.String("sql", fields.SQL))
+	}
+	if len(fields.Args) > 0 {
+		attrs = append(attrs, slog.Any("args", fields.Args))
+	}
+	if fields.Duration > 0 {
+		attrs = append(attrs, slog.Duration("duration", fields.Duration))
+	}
+	if fields.RowsAffected > 0 {
+		attrs = append(attrs, slog.Int64("rows_affected", fields.RowsAffected))
+	}
+	if fields.Err != nil {
+		attrs = append(attrs, slog.Any("error", fields.Err))
+	}
+	if fields.Caller != "" {
+		attrs = append(attrs, slog.String("caller", fields.Caller))
+	}
+	l.logger.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
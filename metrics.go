@@ -0,0 +1,175 @@
+package sqlmaker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQL执行结果的分类，作为指标的outcome标签
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// 指标收集接口，每次真正执行SQL之后都会调用Observe记录一次
+// 该接口不依赖任何第三方库，可以自行适配到Prometheus、StatsD等监控系统
+type Metrics interface {
+	Observe(table string, op Operation, outcome string, duration time.Duration)
+}
+
+var metrics Metrics
+
+// 设置全局的指标收集器，传入nil表示不收集指标
+func SetMetrics(m Metrics) {
+	metrics = m
+}
+
+// 记录一次SQL执行的指标
+func observe(stmt *Statement, duration time.Duration, err error) {
+	if metrics == nil {
+		return
+	}
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	metrics.Observe(stmt.Table, stmt.Op, outcome, duration)
+}
+
+// 默认的延迟直方图分桶(秒)，和Prometheus客户端的默认分桶一致
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 在内存中保存指标的Metrics实现，按照表名、操作类型和执行结果分别统计
+// 执行次数(counter)和延迟(histogram)，可以通过WriteText输出为Prometheus文本格式
+type MemoryMetrics struct {
+	mu      sync.Mutex
+	buckets []float64
+	series  map[metricLabels]*metricSeries
+}
+
+type metricLabels struct {
+	table   string
+	op      Operation
+	outcome string
+}
+
+type metricSeries struct {
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// 新建一个MemoryMetrics，buckets为延迟直方图的分桶上界(秒)，不传入时使用DefaultLatencyBuckets
+func NewMemoryMetrics(buckets ...float64) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &MemoryMetrics{
+		buckets: sorted,
+		series:  make(map[metricLabels]*metricSeries),
+	}
+}
+
+func (m *MemoryMetrics) Observe(table string, op Operation, outcome string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := metricLabels{table: table, op: op, outcome: outcome}
+	series, ok := m.series[labels]
+	if !ok {
+		series = &metricSeries{buckets: make([]uint64, len(m.buckets))}
+		m.series[labels] = series
+	}
+
+	seconds := duration.Seconds()
+	series.count++
+	series.sum += seconds
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			series.buckets[i]++
+		}
+	}
+}
+
+// 返回指定标签的执行次数
+func (m *MemoryMetrics) Count(table string, op Operation, outcome string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.series[metricLabels{table: table, op: op, outcome: outcome}]
+	if !ok {
+		return 0
+	}
+	return series.count
+}
+
+// 将所有指标以Prometheus文本格式写入w，输出顺序是确定的
+func (m *MemoryMetrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricLabels, 0, len(m.series))
+	for labels := range m.series {
+		keys = append(keys, labels)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].table != keys[j].table {
+			return keys[i].table < keys[j].table
+		}
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# HELP sqlmaker_queries_total Total number of executed statements.")
+	fmt.Fprintln(bw, "# TYPE sqlmaker_queries_total counter")
+	for _, labels := range keys {
+		fmt.Fprintf(bw, "sqlmaker_queries_total{%s} %d\n",
+			labels.String(), m.series[labels].count)
+	}
+
+	fmt.Fprintln(bw, "# HELP sqlmaker_query_duration_seconds Statement execution latency in seconds.")
+	fmt.Fprintln(bw, "# TYPE sqlmaker_query_duration_seconds histogram")
+	for _, labels := range keys {
+		series := m.series[labels]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(bw, "sqlmaker_query_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels.String(), formatFloat(bound), series.buckets[i])
+		}
+		fmt.Fprintf(bw, "sqlmaker_query_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n",
+			labels.String(), series.count)
+		fmt.Fprintf(bw, "sqlmaker_query_duration_seconds_sum{%s} %s\n",
+			labels.String(), formatFloat(series.sum))
+		fmt.Fprintf(bw, "sqlmaker_query_duration_seconds_count{%s} %d\n",
+			labels.String(), series.count)
+	}
+
+	return bw.Flush()
+}
+
+func (labels metricLabels) String() string {
+	return fmt.Sprintf("table=\"%s\",operation=\"%s\",outcome=\"%s\"",
+		escapeLabel(labels.table), escapeLabel(string(labels.op)), escapeLabel(labels.outcome))
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 转义标签值中的反斜杠、双引号和换行
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,295 @@
+package sqlmaker
+
+import (
+	"reflect"
+	"strings"
+)
+
+// IN条件每组最多包含的值的数量，为0表示不分组
+var inChunkSize int
+
+// 设置IN条件每组最多包含多少个值，超过的值会被拆分为多个IN条件并用OR连接，
+// 例如`id` IN (?,?) OR `id` IN (?)。默认为0，即不分组
+// 当IN列表非常大时(例如超过了数据库对prepare占位符数量或单个列表长度的限制)可以开启
+func SetInChunkSize(size int) {
+	inChunkSize = size
+}
+
+// 条件表达式语法树的节点，Cond的所有条件最终都会被组织为一棵语法树
+// 渲染语法树不会修改它，因此同一个条件表达式可以被重复渲染，结果总是一致的
+type Node interface {
+	// 将节点渲染为SQL，prepare值会按照出现的顺序记录在r中
+	render(r *renderer)
+}
+
+// 多个条件通过AND连接
+type AndNode struct {
+	Children []Node
+}
+
+// 多个条件通过OR连接
+type OrNode struct {
+	Children []Node
+}
+
+// 条件取反
+type NotNode struct {
+	Child Node
+}
+
+// 比较条件，例如`age`>?，Op为SQL中的比较运算符，例如"="、"!="、">"、"LIKE"、"REGEXP"
+// IgnoreCase为true时会忽略大小写比较，例如LOWER(`name`) LIKE LOWER(?)
+type CompareNode struct {
+	Column     string
+	Op         string
+	Value      interface{}
+	IgnoreCase bool
+}
+
+// BETWEEN条件，Not为true时表示NOT BETWEEN
+type BetweenNode struct {
+	Column string
+	Low    interface{}
+	High   interface{}
+	Not    bool
+}
+
+// IS NULL条件，Not为true时表示IS NOT NULL
+type NullNode struct {
+	Column string
+	Not    bool
+}
+
+// IN条件，Not为true时表示NOT IN
+type InNode struct {
+	Column string
+	Values []interface{}
+	Not    bool
+}
+
+// 括号，对应AndAll/OrAll和EndAll之间的条件
+type GroupNode struct {
+	Child Node
+}
+
+// 语法树渲染器
+type renderer struct {
+	b       strings.Builder
+	prepare bool
+	values  []interface{}
+}
+
+// 渲染node，返回SQL和prepare值
+func renderNode(node Node, prepare bool) (string, []interface{}) {
+	r := &renderer{prepare: prepare}
+	if prepare {
+		r.values = make([]interface{}, 0)
+	}
+	if node != nil {
+		node.render(r)
+	}
+	return r.b.String(), r.values
+}
+
+func (r *renderer) write(s string) {
+	r.b.WriteString(s)
+}
+
+// 写入一个值，prepare时写入"?"并记录值，否则写入字面量
+func (r *renderer) value(v interface{}) {
+	if r.prepare {
+		r.values = append(r.values, v)
+		r.write("?")
+		return
+	}
+	r.write(literalValue(v))
+}
+
+func (node *AndNode) render(r *renderer) {
+	for i, child := range node.Children {
+		if i > 0 {
+			r.write(" AND ")
+		}
+		// AND的优先级高于OR，OR子节点需要加上括号
+		if _, ok := child.(*OrNode); ok {
+			r.write("(")
+			child.render(r)
+			r.write(")")
+		} else {
+			child.render(r)
+		}
+	}
+}
+
+func (node *OrNode) render(r *renderer) {
+	for i, child := range node.Children {
+		if i > 0 {
+			r.write(" OR ")
+		}
+		child.render(r)
+	}
+}
+
+func (node *NotNode) render(r *renderer) {
+	if _, ok := node.Child.(*GroupNode); ok {
+		r.write("NOT ")
+		node.Child.render(r)
+		return
+	}
+	r.write("NOT (")
+	node.Child.render(r)
+	r.write(")")
+}
+
+func (node *CompareNode) render(r *renderer) {
+	if node.IgnoreCase {
+		r.write("LOWER(" + stringName(node.Column) + ")")
+	} else {
+		r.write(stringName(node.Column))
+	}
+	if isWordOp(node.Op) {
+		r.write(" " + node.Op + " ")
+	} else {
+		r.write(node.Op)
+	}
+	if node.IgnoreCase {
+		r.write("LOWER(")
+		r.value(node.Value)
+		r.write(")")
+	} else {
+		r.value(node.Value)
+	}
+}
+
+func (node *BetweenNode) render(r *renderer) {
+	r.write(stringName(node.Column))
+	if node.Not {
+		r.write(" NOT BETWEEN ")
+	} else {
+		r.write(" BETWEEN ")
+	}
+	r.value(node.Low)
+	r.write(" AND ")
+	r.value(node.High)
+}
+
+func (node *NullNode) render(r *renderer) {
+	r.write(stringName(node.Column))
+	if node.Not {
+		r.write(" IS NOT NULL")
+	} else {
+		r.write(" IS NULL")
+	}
+}
+
+func (node *InNode) render(r *renderer) {
+	// 空列表：IN永远为假，NOT IN永远为真
+	if len(node.Values) == 0 {
+		if node.Not {
+			r.write("1=1")
+		} else {
+			r.write("1=0")
+		}
+		return
+	}
+
+	chunks := chunkValues(node.Values, inChunkSize)
+	if len(chunks) > 1 {
+		r.write("(")
+	}
+	for i, chunk := range chunks {
+		if i > 0 {
+			// 值在任意一组中即可满足IN，必须不在所有组中才满足NOT IN
+			if node.Not {
+				r.write(" AND ")
+			} else {
+				r.write(" OR ")
+			}
+		}
+		r.write(stringName(node.Column))
+		if node.Not {
+			r.write(" NOT IN (")
+		} else {
+			r.write(" IN (")
+		}
+		for j, v := range chunk {
+			if j > 0 {
+				r.write(",")
+			}
+			r.value(v)
+		}
+		r.write(")")
+	}
+	if len(chunks) > 1 {
+		r.write(")")
+	}
+}
+
+// 将values按照size分组，size<=0时不分组
+func chunkValues(values []interface{}, size int) [][]interface{} {
+	if size <= 0 || len(values) <= size {
+		return [][]interface{}{values}
+	}
+	chunks := make([][]interface{}, 0, (len(values)+size-1)/size)
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	return append(chunks, values)
+}
+
+func (node *GroupNode) render(r *renderer) {
+	r.write("(")
+	node.Child.render(r)
+	r.write(")")
+}
+
+// 将任意切片或数组展开为[]interface{}，例如[]int、[]string、[]int64
+// []byte会被当作一个值，不是切片的值会被当作只有一个元素的列表
+func sliceValues(vs interface{}) []interface{} {
+	if values, ok := vs.([]interface{}); ok {
+		return values
+	}
+	if _, ok := vs.([]byte); ok {
+		return []interface{}{vs}
+	}
+	rv := reflect.ValueOf(vs)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = rv.Index(i).Interface()
+		}
+		return values
+	case reflect.Invalid:
+		return make([]interface{}, 0)
+	default:
+		return []interface{}{vs}
+	}
+}
+
+// 运算符是否为单词，例如LIKE，单词运算符两侧需要空格
+func isWordOp(op string) bool {
+	return op != "" && (op[0] >= 'A' && op[0] <= 'Z' || op[0] >= 'a' && op[0] <= 'z')
+}
+
+// 前序遍历语法树，fn返回false时不再遍历当前节点的子节点
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *AndNode:
+		for _, child := range n.Children {
+			Walk(child, fn)
+		}
+	case *OrNode:
+		for _, child := range n.Children {
+			Walk(child, fn)
+		}
+	case *NotNode:
+		Walk(n.Child, fn)
+	case *GroupNode:
+		Walk(n.Child, fn)
+	}
+}
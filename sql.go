@@ -2,9 +2,14 @@ package sqlmaker
 
 import "C"
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 // 在使用SqlMaker的时候，如果在Make前没有Build，会返回这个错误
@@ -40,6 +45,33 @@ type SqlMaker struct {
 	// Maker是否已经被构建
 	built bool
 
+	// 是否校验条件表达式中的字段名，见StrictColumns
+	strictColumns bool
+
+	// 通过Bind绑定的entity类型，只用于校验字段名
+	bound Entity
+
+	// 被追踪的entity没有任何字段被修改，此时不会生成更新语句
+	unchanged bool
+
+	// 更新语句中entity的乐观锁版本号字段，见tagVersion
+	version *Field
+
+	// Build时发现的错误，例如版本号字段不是整数，Make时返回
+	buildErr error
+
+	// entity的软删除字段名，为空时表示entity不支持软删除，见tagSoftDelete
+	softDelete string
+
+	// 是否允许没有WHERE条件的更新和删除语句，见AllowFullTable
+	allowFullTable bool
+
+	// 查询时不自动过滤已被软删除的数据，见Unscoped
+	unscoped bool
+
+	// 删除语句不改写为软删除，见HardDelete
+	hardDelete bool
+
 	// 该SQL是否是统计语句，如果是，则SELECT子句为COUNT(1)
 	isCount bool
 
@@ -53,8 +85,22 @@ type SqlMaker struct {
 	limit  int
 	offset int
 
+	// ORDER BY排序字段，"-"前缀表示降序
+	orders []string
+
 	// 如果需要执行SQL语句，必须为db赋值
 	db *sql.DB
+
+	// Maker单独设置的Logger和日志级别，为零值时使用全局设置
+	logger   Logger
+	logLevel LogLevel
+
+	// Maker单独设置的慢查询阈值，为0时使用全局设置
+	slowThreshold time.Duration
+
+	// Maker单独添加的Hook，以及执行SQL时使用的context
+	hooks []Hook
+	ctx   context.Context
 }
 
 // 设置过滤字段名称。如果希望输出的SQL子句只包含entity的部分字段，需要在调用
@@ -65,7 +111,7 @@ func (maker *SqlMaker) Filter(fs ...string) *SqlMaker {
 }
 
 // 设置条件表达式，如果生成的SQL语句有WHERE条件子句，需要调用这个函数设置条件
-// 如果不调用，则不会生成WHERE子句
+// 如果不调用或者条件为空，则不会生成WHERE子句，此时更新和删除语句需要调用AllowFullTable才能生成
 func (maker *SqlMaker) Cond(cond *Cond) *SqlMaker {
 	maker.cond = cond
 	return maker
@@ -82,7 +128,7 @@ func (maker *SqlMaker) Beauty() *SqlMaker {
 	return maker.Split("\n")
 }
 
-// Build()之后调用MustMake()，一次性生成SQL语句返回
+// Build()之后调用MustMake()，一次性生成SQL语句返回，Make()返回错误时会panic，见MustMake
 func (maker *SqlMaker) BuildMake() string {
 	maker.Build()
 	return maker.MustMake()
@@ -106,27 +152,154 @@ func (maker *SqlMaker) Prepare(prepare bool) *SqlMaker {
 // 这个函数非常重要，在exec执行的时候依据这个函数判断是否使用PrepareStmt
 func (maker *SqlMaker) IsPrepare() bool {
 
-	if !maker.hasPrepareStat() && maker.cond == nil {
+	cond := maker.where()
+	if !maker.hasPrepareStat() && cond == nil {
 		return false
 	}
 
 	return maker.maker.prepare ||
-		(maker.cond != nil && maker.cond.values != nil)
+		(cond != nil && cond.prepare)
 }
 
 // 构建SQL语句，但是不生成，这会解析entity对象
 // 调用该函数之后就可以调用Make()生成SQL语句了
 // 当entity对象改变时，注意Make()仍然返回之前那个entity的SQL语句
 // 如果需要生成新的，需要重新调用该函数
+// 如果是更新语句，并且entity通过Track被追踪，则只会SET被修改过的字段
+// 新建和更新语句不会包含软删除字段，软删除字段只能通过删除语句设置
+// 新建和更新语句会自动填充创建时间和更新时间字段，见tagAutoCreateTime和tagAutoUpdateTime
 func (maker *SqlMaker) Build() *SqlMaker {
+	if maker.maker.built {
+		maker.built = true
+		return maker
+	}
 	maker.maker.Build()
+	switch maker.operation() {
+	case OpInsert, OpReplace:
+		maker.removeField(maker.softDelete)
+		maker.fillCreateTime()
+	case OpUpdate:
+		maker.removeField(maker.softDelete)
+		if snapshot, ok := trackedSnapshot(maker.maker.entity); ok {
+			maker.maker.fields = changedFields(maker.maker.fields, snapshot)
+			maker.unchanged = len(maker.maker.fields) == 0 && len(maker.maker.sets) == 0
+		}
+		if !maker.unchanged {
+			maker.fillUpdateTime()
+		}
+		maker.buildVersion()
+	}
 	maker.built = true
 	return maker
 }
 
+// 如果entity有乐观锁版本号字段，将其从SET子句中去掉，改为SET version=version+1，
+// 并记录当前的版本号，用于在WHERE子句中增加"AND version=?"
+func (maker *SqlMaker) buildVersion() {
+	if maker.maker.entity == nil {
+		return
+	}
+	version, ok := optionField(maker.maker.entity, tagVersion)
+	if !ok {
+		return
+	}
+	switch reflect.ValueOf(version.originVal).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		maker.buildErr = fmt.Errorf("version field %s must be an integer, got %T", version.Name, version.originVal)
+		return
+	}
+
+	maker.removeField(version.TableFieldName)
+	maker.maker.Set(version.TableFieldName, stringName(version.TableFieldName)+"+1", nil)
+	maker.version = &version
+}
+
+// 从解析出的entity字段中去掉数据表字段名为column的字段
+func (maker *SqlMaker) removeField(column string) {
+	if column == "" {
+		return
+	}
+	fields := make([]Field, 0, len(maker.maker.fields))
+	for _, field := range maker.maker.fields {
+		if field.TableFieldName != column {
+			fields = append(fields, field)
+		}
+	}
+	maker.maker.fields = fields
+}
+
+// 返回WHERE子句实际使用的条件，除了通过Cond设置的条件，还包括乐观锁版本号、
+// 软删除过滤等自动增加的条件。没有任何条件时(包括设置的条件为空)返回nil，此时不会生成WHERE子句
+func (maker *SqlMaker) where() *Cond {
+	conds := []*Cond{maker.cond}
+	if maker.version != nil {
+		conds = append(conds, Eq(maker.version.TableFieldName, maker.version.originVal))
+	}
+	if maker.scoped() {
+		conds = append(conds, IsNull(maker.softDelete))
+	}
+	cond := maker.cond
+	if len(conds) > 1 {
+		cond = And(conds...)
+	}
+	if cond == nil || cond.Empty() {
+		return nil
+	}
+	return cond
+}
+
+// 是否需要自动过滤已被软删除的数据，查询、统计以及软删除语句本身都会过滤
+func (maker *SqlMaker) scoped() bool {
+	if maker.softDelete == "" || maker.unscoped {
+		return false
+	}
+	switch maker.operation() {
+	case OpSelect, OpCount:
+		return true
+	case OpDelete:
+		return !maker.hardDelete
+	}
+	return false
+}
+
+// 允许生成没有WHERE条件的更新和删除语句
+// 为了防止忘记设置条件而修改整张表的数据，默认情况下这样的语句在Make时会返回ErrUnsafeStatement，
+// 确实需要更新或删除整张表时才调用该函数
+func (maker *SqlMaker) AllowFullTable() *SqlMaker {
+	maker.allowFullTable = true
+	return maker
+}
+
+// 是否为没有设置任何条件的更新或删除语句，乐观锁版本号、软删除过滤等自动增加的条件不计算在内
+func (maker *SqlMaker) unsafe() bool {
+	if maker.allowFullTable || (maker.cond != nil && !maker.cond.Empty()) {
+		return false
+	}
+	op := maker.operation()
+	return op == OpUpdate || op == OpDelete
+}
+
+// 查询和统计时不再自动增加"deleted_at IS NULL"条件，即同时查询已被软删除的数据
+func (maker *SqlMaker) Unscoped() *SqlMaker {
+	maker.unscoped = true
+	return maker
+}
+
+// 删除语句不再改写为软删除，而是真正删除数据，包括已被软删除的数据
+func (maker *SqlMaker) HardDelete() *SqlMaker {
+	maker.hardDelete = true
+	return maker
+}
+
 // 直接将条件设置为根据ID查询。这需要entity通过getId()函数返回id字段名和值
 // 这样可以直接将WHERE子句设置为idName=idValue
+// 没有id字段名时(例如NewTableUpdateMaker)不会设置任何条件，更新和删除语句因此会返回ErrUnsafeStatement
 func (maker *SqlMaker) ByID() *SqlMaker {
+	if maker.idName == "" {
+		return maker
+	}
 	if maker.IsPrepare() {
 		maker.cond = NewPrepareCond().Eq(maker.idName, maker.idValue)
 	} else {
@@ -135,6 +308,78 @@ func (maker *SqlMaker) ByID() *SqlMaker {
 	return maker
 }
 
+// 开启字段校验，Make时会检查条件表达式和排序中的字段名是否都是entity的字段(即"field"标签)
+// 如果存在未知字段，Make返回ErrUnknownColumn。当条件中的字段名来自用户输入时(例如API的排序和筛选参数)，
+// 建议开启该校验。字段名可以带有表名前缀，例如"user.name"
+func (maker *SqlMaker) StrictColumns() *SqlMaker {
+	maker.strictColumns = true
+	return maker
+}
+
+// 检查条件表达式、排序和SET子句中的字段名是否都是entity的字段
+func (maker *SqlMaker) checkColumns() error {
+	entity := maker.maker.entity
+	if entity == nil {
+		entity = maker.bound
+	}
+	if !maker.strictColumns || entity == nil {
+		return nil
+	}
+	known := tableFieldNames(reflect.TypeOf(entity))
+	prefix := maker.maker.tableName + "."
+	// 只校验调用者设置的字段，乐观锁版本号、软删除等Maker自动增加的条件不需要校验
+	columns := make([]string, 0)
+	if maker.cond != nil {
+		columns = maker.cond.Columns()
+	}
+	for _, order := range maker.orders {
+		columns = append(columns, strings.TrimPrefix(order, "-"))
+	}
+	for _, set := range maker.maker.sets {
+		columns = append(columns, set.column)
+	}
+	for _, column := range columns {
+		if !known[strings.TrimPrefix(column, prefix)] {
+			return fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+	}
+	return nil
+}
+
+// 在SET子句中追加一个赋值，生成"`column`=?"
+func (maker *SqlMaker) Set(column string, v interface{}) *SqlMaker {
+	maker.maker.Set(column, "?", []interface{}{v})
+	return maker
+}
+
+// 在SET子句中追加一个表达式赋值，例如SetExpr("login_count", "login_count + ?", 1)
+// 生成"`login_count`=login_count + ?"。注意expr会被原样拼接进SQL，不要使用用户输入作为expr，
+// 用户输入的值应该通过args传入
+func (maker *SqlMaker) SetExpr(column, expr string, args ...interface{}) *SqlMaker {
+	maker.maker.Set(column, expr, args)
+	return maker
+}
+
+// 在SET子句中追加map中的所有赋值，按照字段名排序，保证生成的SQL是确定的
+func (maker *SqlMaker) SetMap(values map[string]interface{}) *SqlMaker {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		maker.Set(column, values[column])
+	}
+	return maker
+}
+
+// 为Maker绑定entity类型，用于NewTableUpdateMaker，绑定后会自动开启字段校验(见StrictColumns)
+func (maker *SqlMaker) Bind(e Entity) *SqlMaker {
+	maker.bound = e
+	maker.strictColumns = true
+	return maker
+}
+
 // 查询结果以统计的方式返回。这将SELECT子句设置为"SELECT COUNT(1)"
 // 会返回查询到的数量而不是数据
 func (maker *SqlMaker) Count() *SqlMaker {
@@ -149,6 +394,13 @@ func (maker *SqlMaker) Limit(limit, offset int) *SqlMaker {
 	return maker
 }
 
+// 设置查询的排序字段，字段名前加"-"表示降序，例如OrderBy("-create_date", "id")
+// 会生成"ORDER BY `create_date` DESC,`id` ASC"，多次调用会追加排序字段
+func (maker *SqlMaker) OrderBy(columns ...string) *SqlMaker {
+	maker.orders = append(maker.orders, columns...)
+	return maker
+}
+
 // 分页，这会自动根据curPage和pageSize来计算LIMIT参数
 func (maker *SqlMaker) Page(curPage, pageSize int) *SqlMaker {
 	return maker.Limit((curPage-1)*pageSize, pageSize)
@@ -168,11 +420,11 @@ func (maker *SqlMaker) Values() []interface{} {
 	if !maker.hasPrepareStat() {
 		maker.maker.prepare = false
 	}
-	if maker.cond != nil && maker.cond.values != nil {
+	if cond := maker.where(); cond != nil && cond.prepare {
 		if maker.maker.prepare {
-			return append(maker.maker.GetValues(), maker.cond.values...)
+			return append(maker.maker.GetValues(), cond.Values()...)
 		}
-		return maker.cond.values
+		return cond.Values()
 	}
 	if maker.maker.prepare {
 		return maker.maker.GetValues()
@@ -196,12 +448,24 @@ func (maker *SqlMaker) hasPrepareStat() bool {
 
 // 生成SQL语句
 // 这会根据配置和解析的entity生成SQL语句，注意调用该函数前必须调用Build()函数
-// 否则会返回MakerNotBuildError错误
+// 否则会返回MakerNotBuildError错误。没有条件的更新和删除语句会返回ErrUnsafeStatement，见AllowFullTable
 func (maker *SqlMaker) Make() (string, error) {
 
 	if !maker.built {
 		return "", MakerNotBuildError
 	}
+	if maker.buildErr != nil {
+		return "", maker.buildErr
+	}
+	if maker.unchanged {
+		return "", nil
+	}
+	if maker.unsafe() {
+		return "", ErrUnsafeStatement
+	}
+	if err := maker.checkColumns(); err != nil {
+		return "", err
+	}
 
 	_sql := make([]string, 0)
 	for _, stat := range maker.statOrder {
@@ -219,14 +483,25 @@ func (maker *SqlMaker) Make() (string, error) {
 		case "set":
 			_sql = append(_sql, maker.maker.MakeSet())
 		case "where":
-			if maker.cond == nil {
+			cond := maker.where()
+			if cond == nil {
 				continue
 			}
-			_sql = append(_sql, maker.maker.MakeWhere(maker.cond))
+			_sql = append(_sql, maker.maker.MakeWhere(cond))
 		case "delete":
+			if maker.softDelete != "" && !maker.hardDelete {
+				_sql = append(_sql, maker.maker.MakeUpdate(),
+					maker.maker.MakeSoftDelete(maker.softDelete))
+				continue
+			}
 			_sql = append(_sql, maker.maker.MakeDelete())
 		case "select":
 			_sql = append(_sql, maker.maker.MakeSelect(maker.isCount))
+		case "order":
+			if len(maker.orders) == 0 {
+				continue
+			}
+			_sql = append(_sql, maker.maker.MakeOrder(maker.orders))
 		case "limit":
 			if maker.limit == -1 {
 				continue
@@ -240,7 +515,9 @@ func (maker *SqlMaker) Make() (string, error) {
 	return strings.Join(_sql, maker.split), nil
 }
 
-// 和Make()一样，但是如果没有Build()，会直接panic
+// 和Make()一样，但是Make()返回的任何错误都会直接panic，包括没有Build()、
+// 没有条件的更新和删除语句(ErrUnsafeStatement)以及开启字段校验后的未知字段(ErrUnknownColumn)
+// 只建议在确定SQL一定可以生成的时候使用，例如测试中
 func (maker *SqlMaker) MustMake() string {
 	s, err := maker.Make()
 	if err != nil {
@@ -264,28 +541,48 @@ func NewUpdateMaker(e Entity) *SqlMaker {
 	return newSqlMaker(e, []string{"update", "set", "where"})
 }
 
+// 新建一个不依赖entity的更新SQL语句生成器，通过Set、SetExpr和SetMap设置需要更新的字段
+// 例如 NewTableUpdateMaker("user").Set("status", 3).SetExpr("login_count", "login_count + ?", 1).Cond(cond)
+// 如果通过Bind绑定了entity类型，Make时会校验所有字段名是否是entity的字段
+// 由于没有entity，该Maker不能使用ByID()，调用ByID()不会设置任何条件
+func NewTableUpdateMaker(table string) *SqlMaker {
+	return &SqlMaker{
+		maker:     NewTableStatMaker(table),
+		split:     " ",
+		statOrder: []string{"update", "set", "where"},
+		cond:      nil,
+		built:     false,
+		limit:     -1,
+		offset:    -1,
+	}
+}
+
 // 新建一个删除SQL语句生成器
+// 如果entity有软删除字段，删除语句会被改写为"UPDATE ... SET `deleted_at`=NOW()"，
+// 并且只会删除还没有被软删除的数据，需要真正删除时调用HardDelete
 func NewDeleteMaker(e Entity) *SqlMaker {
 	return newSqlMaker(e, []string{"delete", "where"})
 }
 
 // 新建一个查询语句生成器
+// 如果entity有软删除字段，查询和统计时会自动增加"`deleted_at` IS NULL"条件，可以通过Unscoped取消
 func NewQueryMaker(e Entity) *SqlMaker {
-	return newSqlMaker(e, []string{"select", "from", "where", "limit"})
+	return newSqlMaker(e, []string{"select", "from", "where", "order", "limit"})
 }
 
 func newSqlMaker(e Entity, statOrder []string) *SqlMaker {
 	idName, idValue := e.GetId()
 	return &SqlMaker{
-		maker:     NewStatMaker(e),
-		split:     " ",
-		statOrder: statOrder,
-		cond:      nil,
-		built:     false,
-		idName:    stringName(idName),
-		idValue:   idValue,
-		limit:     -1,
-		offset:    -1,
+		maker:      NewStatMaker(e),
+		split:      " ",
+		statOrder:  statOrder,
+		cond:       nil,
+		built:      false,
+		idName:     idName,
+		idValue:    idValue,
+		softDelete: softDeleteColumn(e),
+		limit:      -1,
+		offset:     -1,
 	}
 
 }
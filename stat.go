@@ -18,6 +18,7 @@ const (
 	_DELETE  = "DELETE FROM %s"
 	_LIMIT   = "LIMIT %s"
 	_REPLACE = "REPLACE INTO %s(%s)"
+	_ORDER   = "ORDER BY %s"
 )
 
 // SQL子句生成器，用于根据Entity生成所有已知的SQL子句
@@ -28,6 +29,16 @@ type StatMaker struct {
 	built     bool
 	tableName string
 	prepare   bool
+
+	// 通过Set、SetExpr直接设置的SET子句，会追加在entity字段之后
+	sets []setClause
+}
+
+// SET子句中的一个赋值，expr中的"?"和args一一对应
+type setClause struct {
+	column string
+	expr   string
+	args   []interface{}
 }
 
 // 创建一个SQL子句生成器，需要传入entity表示这个生成器是针对哪个实体的
@@ -45,7 +56,9 @@ func NewStatMaker(entity Entity) StatMaker {
 // 之后，生成器就会实际的解析entity。在调用Make函数之前，必须调用这个函数
 func (maker *StatMaker) Build() {
 	if !maker.built {
-		maker.fields = decodeEntity(maker.entity, maker.filter)
+		if maker.entity != nil {
+			maker.fields = decodeEntity(maker.entity, maker.filter)
+		}
 		maker.built = true
 	}
 }
@@ -56,6 +69,24 @@ func (maker *StatMaker) Filter(filter []string) {
 	maker.filter = filter
 }
 
+// 新建一个不依赖entity的子句生成器，只能生成和表名相关的子句，以及通过Set设置的SET子句
+func NewTableStatMaker(tableName string) StatMaker {
+	return StatMaker{
+		built:     false,
+		tableName: tableName,
+		prepare:   true,
+	}
+}
+
+// 在SET子句中追加一个赋值，生成"`column`=expr"，expr中的"?"会被替换为args
+func (maker *StatMaker) Set(column, expr string, args []interface{}) {
+	maker.sets = append(maker.sets, setClause{
+		column: column,
+		expr:   expr,
+		args:   args,
+	})
+}
+
 func (maker *StatMaker) Prepare(prepare bool) {
 	maker.prepare = prepare
 }
@@ -113,6 +144,24 @@ func (maker *StatMaker) MakeDelete() string {
 	return fmt.Sprintf(_DELETE, maker.tableName)
 }
 
+// 生成软删除的SET子句，将软删除字段设置为当前时间
+func (maker *StatMaker) MakeSoftDelete(column string) string {
+	return fmt.Sprintf(_SET, stringName(column)+"=NOW()")
+}
+
+// 生成ORDER BY子句，字段名前的"-"表示降序
+func (maker *StatMaker) MakeOrder(orders []string) string {
+	stat := make([]string, 0, len(orders))
+	for _, order := range orders {
+		if strings.HasPrefix(order, "-") {
+			stat = append(stat, stringName(order[1:])+" DESC")
+		} else {
+			stat = append(stat, stringName(order)+" ASC")
+		}
+	}
+	return fmt.Sprintf(_ORDER, strings.Join(stat, ","))
+}
+
 // 生成LIMIT子句
 func (maker *StatMaker) MakeLimit(limit, offset int) string {
 	if offset == -1 {
@@ -128,6 +177,9 @@ func (maker *StatMaker) GetValues() []interface{} {
 	for _, field := range maker.fields {
 		ret = append(ret, field.originVal)
 	}
+	for _, set := range maker.sets {
+		ret = append(ret, set.args...)
+	}
 	return ret
 }
 
@@ -144,16 +196,28 @@ func (maker *StatMaker) makeEquals() string {
 	var genFunc genStatFunc
 	if !maker.prepare {
 		genFunc = func(field Field) string {
-			return fmt.Sprintf("`%s`=%s",
-				field.TableFieldName, field.val)
+			return fmt.Sprintf("%s=%s",
+				stringName(field.TableFieldName), field.val)
 		}
 	} else {
 		genFunc = func(field Field) string {
-			return fmt.Sprintf("`%s`=?",
-				field.TableFieldName)
+			return fmt.Sprintf("%s=?",
+				stringName(field.TableFieldName))
 		}
 	}
-	return maker.makeStat(genFunc)
+
+	stat := maker.makeStat(genFunc)
+	for _, set := range maker.sets {
+		expr := set.expr
+		if !maker.prepare {
+			expr = interpolate(expr, set.args)
+		}
+		if stat != "" {
+			stat += ","
+		}
+		stat += stringName(set.column) + "=" + expr
+	}
+	return stat
 }
 
 // 生成所有字段的值
@@ -174,7 +238,7 @@ func (maker *StatMaker) makeValues() string {
 // 生成所有字段的名称
 func (maker *StatMaker) makeNames() string {
 	return maker.makeStat(func(field Field) string {
-		return "`" + field.TableFieldName + "`"
+		return stringName(field.TableFieldName)
 	})
 }
 
@@ -0,0 +1,96 @@
+package sqlmaker
+
+import (
+	"reflect"
+	"time"
+)
+
+// 自动填充时间戳使用的时钟
+var clock = time.Now
+
+// 设置自动填充创建时间和更新时间使用的时钟，默认为time.Now，传入nil时恢复默认
+// 测试时可以设置为返回固定时间的函数，使生成的SQL和值是确定的
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+}
+
+// 为新建语句填充创建时间和更新时间字段，只会填充没有被Filter过滤掉并且为零值的字段
+func (maker *SqlMaker) fillCreateTime() {
+	now := clock()
+	for _, opt := range []string{tagAutoCreateTime, tagAutoUpdateTime} {
+		for _, field := range maker.timeFields(opt) {
+			if maker.hasField(field.Name) && maker.timeValue(field.Name).IsZero() {
+				maker.setTime(field, now)
+			}
+		}
+	}
+}
+
+// 为更新语句刷新更新时间字段，即使该字段被Filter过滤掉，也会被加入SET子句
+func (maker *SqlMaker) fillUpdateTime() {
+	now := clock()
+	for _, field := range maker.timeFields(tagAutoUpdateTime) {
+		maker.setTime(field, now)
+	}
+}
+
+// 返回entity中带有标签选项opt的time.Time类型字段，不受Filter影响
+func (maker *SqlMaker) timeFields(opt string) []Field {
+	fields := make([]Field, 0)
+	if maker.maker.entity == nil {
+		return fields
+	}
+	t := reflect.Indirect(reflect.ValueOf(maker.maker.entity)).Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts := parseTag(field)
+		if opts.has(opt) && field.Type == reflect.TypeOf(time.Time{}) {
+			fields = append(fields, Field{Name: field.Name, TableFieldName: name, opts: opts})
+		}
+	}
+	return fields
+}
+
+// 解析出的字段中是否包含属性name
+func (maker *SqlMaker) hasField(name string) bool {
+	for _, field := range maker.maker.fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回entity中time.Time类型属性name的值
+func (maker *SqlMaker) timeValue(name string) time.Time {
+	v := reflect.Indirect(reflect.ValueOf(maker.maker.entity)).FieldByName(name)
+	return v.Interface().(time.Time)
+}
+
+// 将字段设置为now，已经解析出该字段时原地替换，否则追加在最后
+// 如果entity是指针，还会将now写回entity对应的属性
+func (maker *SqlMaker) setTime(field Field, now time.Time) {
+	field.val = dateToString(now)
+	field.originVal = now
+
+	replaced := false
+	for i := range maker.maker.fields {
+		if maker.maker.fields[i].Name == field.Name {
+			maker.maker.fields[i] = field
+			replaced = true
+		}
+	}
+	if !replaced {
+		maker.maker.fields = append(maker.maker.fields, field)
+	}
+
+	v := reflect.ValueOf(maker.maker.entity)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if attr := v.Elem().FieldByName(field.Name); attr.CanSet() {
+			attr.Set(reflect.ValueOf(now))
+		}
+	}
+}
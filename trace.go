@@ -0,0 +1,136 @@
+package sqlmaker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 分布式追踪的抽象，每次真正执行SQL时都会通过Tracer创建一个Span
+// 可以自行适配到OpenTelemetry、Jaeger等追踪系统
+type Tracer interface {
+	// 创建一个Span，ctx中的Span应该作为新Span的父Span
+	// 返回的context会被用于执行SQL
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// 追踪中的一个Span
+type Span interface {
+	SetAttributes(attrs map[string]interface{})
+	RecordError(err error)
+	End()
+}
+
+var tracer Tracer
+
+// 设置全局Tracer，传入nil表示不追踪
+func SetTracer(t Tracer) {
+	tracer = t
+}
+
+// 为Statement创建Span，名称为"操作类型 表名"，例如"select user"
+// 没有设置Tracer时返回的Span为nil
+func startSpan(ctx context.Context, stmt *Statement) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := tracer.StartSpan(ctx, string(stmt.Op)+" "+stmt.Table)
+	span.SetAttributes(map[string]interface{}{
+		"db.system":    "mysql",
+		"db.statement": stmt.SQL,
+		"db.sql.table": stmt.Table,
+		"db.operation": string(stmt.Op),
+	})
+	return ctx, span
+}
+
+// 记录执行结果并结束Span
+func endSpan(span Span, rows int64, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(map[string]interface{}{
+			"db.rows_affected": rows,
+		})
+	}
+	span.End()
+}
+
+// 在内存中记录所有Span的Tracer，一般用于测试
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// 被SpanRecorder记录的Span
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan
+	Attributes map[string]interface{}
+	Errors     []error
+	Start      time.Time
+	EndTime    time.Time
+
+	recorder *SpanRecorder
+}
+
+type spanContextKey struct{}
+
+// 新建一个SpanRecorder
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+func (recorder *SpanRecorder) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanContextKey{}).(*RecordedSpan)
+	span := &RecordedSpan{
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]interface{}),
+		Start:      time.Now(),
+		recorder:   recorder,
+	}
+
+	recorder.mu.Lock()
+	recorder.spans = append(recorder.spans, span)
+	recorder.mu.Unlock()
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// 返回所有已经创建的Span，按照创建顺序排列
+func (recorder *SpanRecorder) Spans() []*RecordedSpan {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]*RecordedSpan{}, recorder.spans...)
+}
+
+func (span *RecordedSpan) SetAttributes(attrs map[string]interface{}) {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	for k, v := range attrs {
+		span.Attributes[k] = v
+	}
+}
+
+func (span *RecordedSpan) RecordError(err error) {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	span.Errors = append(span.Errors, err)
+}
+
+func (span *RecordedSpan) End() {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	span.EndTime = time.Now()
+}
+
+// Span是否已经结束
+func (span *RecordedSpan) Ended() bool {
+	span.recorder.mu.Lock()
+	defer span.recorder.mu.Unlock()
+	return !span.EndTime.IsZero()
+}
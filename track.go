@@ -0,0 +1,62 @@
+package sqlmaker
+
+import (
+	"reflect"
+	"sync"
+)
+
+// 被追踪的entity的快照，key为entity指针，value为属性名到属性值的映射
+var (
+	trackedMu sync.Mutex
+	tracked   = make(map[interface{}]map[string]interface{})
+)
+
+// 记录entity当前的值作为快照，e必须是结构体指针，例如Track(&user)
+// 之后通过NewUpdateMaker(&user)生成的更新语句只会SET自快照之后被修改过的字段，
+// 避免覆盖其它地方对未修改字段的并发更新。如果没有任何字段被修改，Make返回空字符串，Exec不会执行任何SQL
+// 每次Exec成功之后快照都会被刷新。不再需要追踪时，应该调用Untrack释放快照
+func Track(e Entity) {
+	v := reflect.ValueOf(e)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+
+	snapshot := make(map[string]interface{})
+	for _, field := range decodeEntity(e, nil) {
+		snapshot[field.Name] = field.originVal
+	}
+
+	trackedMu.Lock()
+	tracked[e] = snapshot
+	trackedMu.Unlock()
+}
+
+// 停止追踪entity，释放它的快照
+func Untrack(e Entity) {
+	trackedMu.Lock()
+	delete(tracked, e)
+	trackedMu.Unlock()
+}
+
+// 返回entity的快照
+func trackedSnapshot(e Entity) (map[string]interface{}, bool) {
+	if e == nil || reflect.ValueOf(e).Kind() != reflect.Ptr {
+		return nil, false
+	}
+	trackedMu.Lock()
+	defer trackedMu.Unlock()
+	snapshot, ok := tracked[e]
+	return snapshot, ok
+}
+
+// 从fields中去掉和快照相比没有被修改的字段
+func changedFields(fields []Field, snapshot map[string]interface{}) []Field {
+	changed := make([]Field, 0, len(fields))
+	for _, field := range fields {
+		if origin, ok := snapshot[field.Name]; ok && reflect.DeepEqual(origin, field.originVal) {
+			continue
+		}
+		changed = append(changed, field)
+	}
+	return changed
+}